@@ -0,0 +1,252 @@
+// Package balancer spreads calls to a service over all of its replicas,
+// as found by a registry.Resolver.
+package balancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/registry"
+)
+
+// downTime is how long an endpoint that could not be reached is skipped.
+const downTime = time.Second
+
+// connectWait is how long a call waits for a connection to one endpoint
+// before it tries another.
+const connectWait = 500 * time.Millisecond
+
+// Endpoint is one replica of the service and its pool of connections,
+// dialed on first use.
+type Endpoint struct {
+	Addr string
+
+	outstanding atomic.Int64
+
+	mu        sync.Mutex
+	pool      *client.Pool
+	downUntil time.Time
+	removed   bool // no longer resolved; closed once its calls are done
+}
+
+// Outstanding returns the number of calls in flight to the endpoint.
+func (e *Endpoint) Outstanding() int64 {
+	return e.outstanding.Load()
+}
+
+func (e *Endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.After(e.downUntil)
+}
+
+func (e *Endpoint) markDown() {
+	e.mu.Lock()
+	e.downUntil = time.Now().Add(downTime)
+	e.mu.Unlock()
+}
+
+// connPool returns the endpoint's pool, creating it if there is none. It
+// returns nil once the endpoint has been removed.
+func (e *Endpoint) connPool(opts []client.Option) *client.Pool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pool == nil && !e.removed {
+		e.pool = client.NewPool("tcp", e.Addr, opts...)
+	}
+	return e.pool
+}
+
+func (e *Endpoint) close() {
+	e.mu.Lock()
+	p := e.pool
+	e.pool = nil
+	e.mu.Unlock()
+	if p != nil {
+		p.Close()
+	}
+}
+
+// retire closes the endpoint once it has no calls left in flight.
+func (e *Endpoint) retire() {
+	e.mu.Lock()
+	e.removed = true
+	e.mu.Unlock()
+	if e.Outstanding() == 0 {
+		e.close()
+	}
+}
+
+// release ends a call started on the endpoint.
+func (e *Endpoint) release() {
+	if e.outstanding.Add(-1) > 0 {
+		return
+	}
+	e.mu.Lock()
+	removed := e.removed
+	e.mu.Unlock()
+	if removed {
+		e.close()
+	}
+}
+
+// Balancer calls the replicas of one service, choosing one per call with
+// its Policy. Each replica gets a client.Pool, which redials dropped
+// connections and retries idempotent calls; replicas that cannot be
+// reached are skipped for a while and the call goes to another one.
+type Balancer struct {
+	service    string
+	resolver   registry.Resolver
+	policy     Policy
+	clientOpts []client.Option
+	refresh    time.Duration
+
+	mu        sync.Mutex
+	endpoints []*Endpoint
+	resolved  time.Time
+	closed    bool
+}
+
+func New(service string, resolver registry.Resolver, opts ...Option) *Balancer {
+	b := &Balancer{
+		service:  service,
+		resolver: resolver,
+		policy:   RoundRobin(),
+		refresh:  10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Call invokes serviceMethod on one of the replicas, like client.Call.
+func (b *Balancer) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+	endpoints, err := b.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var candidates []*Endpoint
+	for _, ep := range endpoints {
+		if ep.available(now) {
+			candidates = append(candidates, ep)
+		}
+	}
+
+	for len(candidates) > 0 {
+		ep := b.policy.Pick(candidates)
+		ep.outstanding.Add(1)
+		if pool := ep.connPool(b.clientOpts); pool != nil {
+			err = callPool(ctx, pool, serviceMethod, args, reply)
+		} else {
+			err = client.ErrNoConnection // removed since we resolved
+		}
+		ep.release()
+		if !errors.Is(err, client.ErrNoConnection) || ctx.Err() != nil {
+			return err
+		}
+		// the call was never sent, so another replica can take it
+		slog.Warn("endpoint unreachable", "service", b.service, "addr", ep.Addr, "error", err)
+		ep.markDown()
+		candidates = slices.DeleteFunc(candidates, func(c *Endpoint) bool { return c == ep })
+	}
+	return protocol.Errorf(protocol.CodeUnavailable, "no reachable endpoints for service %q", b.service)
+}
+
+// callPool makes the call on pool once it has a connection. The pool keeps
+// redialing for as long as it is asked to wait, so an endpoint that has
+// none within connectWait is given up on with ErrNoConnection.
+func callPool(ctx context.Context, pool *client.Pool, serviceMethod string, args, reply any) error {
+	wait, cancel := context.WithTimeout(ctx, connectWait)
+	_, err := pool.Get(wait)
+	cancel()
+	if err != nil {
+		return err
+	}
+	return pool.Call(ctx, serviceMethod, args, reply)
+}
+
+// Close closes the connections to every endpoint.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	endpoints := b.endpoints
+	b.endpoints = nil
+	b.closed = true
+	b.mu.Unlock()
+	for _, ep := range endpoints {
+		ep.retire()
+	}
+	return nil
+}
+
+// resolve returns the current endpoints, resolving the service name again
+// when the list is older than the refresh interval. If that fails, the
+// old list is kept as long as there is one. The resolver may be slow or
+// remote, so it runs without b.mu held.
+func (b *Balancer) resolve(ctx context.Context) ([]*Endpoint, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, client.ErrShutdown
+	}
+	if b.endpoints != nil && time.Since(b.resolved) < b.refresh {
+		endpoints := b.endpoints
+		b.mu.Unlock()
+		return endpoints, nil
+	}
+	b.mu.Unlock()
+
+	addrs, err := b.resolver.Resolve(ctx, b.service)
+	if errors.Is(err, protocol.ErrNotFound) {
+		addrs, err = nil, nil // every replica is gone
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, client.ErrShutdown
+	}
+	if err != nil {
+		if b.endpoints != nil {
+			slog.Warn("failed to resolve service; using previous endpoints", "service", b.service, "error", err)
+			return b.endpoints, nil
+		}
+		return nil, err
+	}
+
+	// another call may have swapped the list in the meantime; endpoints
+	// are matched against whatever is current
+	existing := make(map[string]*Endpoint, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		existing[ep.Addr] = ep
+	}
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		ep, ok := existing[addr]
+		if ok {
+			delete(existing, addr)
+		} else {
+			ep = &Endpoint{Addr: addr}
+		}
+		endpoints = append(endpoints, ep)
+	}
+	for _, ep := range existing {
+		slog.Info("endpoint removed", "service", b.service, "addr", ep.Addr)
+		ep.retire()
+	}
+	if len(endpoints) == 0 {
+		b.endpoints = nil // resolve again on the next call
+		return nil, protocol.Errorf(protocol.CodeNotFound, "no endpoints for service %q", b.service)
+	}
+	b.endpoints = endpoints
+	b.resolved = time.Now()
+	return endpoints, nil
+}
@@ -0,0 +1,271 @@
+package balancer_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/balancer"
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/registry"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+// who answers with the name of its replica, after sleeping the given
+// number of milliseconds.
+type who struct{ id string }
+
+func (w who) Name(ctx context.Context, ms *int, reply *string) error {
+	time.Sleep(time.Duration(*ms) * time.Millisecond)
+	*reply = w.id
+	return nil
+}
+
+// startReplica serves a who named id and returns its address.
+func startReplica(t *testing.T, id string) string {
+	t.Helper()
+	s := server.NewServer()
+	if err := s.Register("Who", who{id: id}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func callN(t *testing.T, b *balancer.Balancer, n int) map[string]int {
+	t.Helper()
+	count := make(map[string]int)
+	for range n {
+		var id string
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+		count[id]++
+	}
+	return count
+}
+
+func TestBalancerFile(t *testing.T) {
+	f := registry.NewFile(filepath.Join(t.TempDir(), "registry.json"))
+	addrs := make(map[string]string)
+	for _, id := range []string{"a", "b", "c"} {
+		addrs[id] = startReplica(t, id)
+		if err := f.Announce("Who", addrs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const refresh = 30 * time.Millisecond
+	b := balancer.New("Who", f, balancer.WithRefreshInterval(refresh))
+	defer b.Close()
+
+	if got := callN(t, b, 9); got["a"] != 3 || got["b"] != 3 || got["c"] != 3 {
+		t.Fatalf("round robin spread calls as %v, want 3 each", got)
+	}
+
+	// a withdrawn replica stops getting calls after the next refresh
+	if err := f.Withdraw("Who", addrs["b"]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * refresh)
+	if got := callN(t, b, 6); got["b"] != 0 || got["a"] != 3 || got["c"] != 3 {
+		t.Fatalf("after withdrawing b, calls went %v", got)
+	}
+
+	// an unreachable replica is skipped
+	if err := f.Announce("Who", "127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * refresh)
+	if got := callN(t, b, 6); got["a"]+got["c"] != 6 {
+		t.Fatalf("with an unreachable replica, calls went %v", got)
+	}
+}
+
+func TestBalancerNoEndpoints(t *testing.T) {
+	f := registry.NewFile(filepath.Join(t.TempDir(), "registry.json"))
+	b := balancer.New("Who", f)
+	defer b.Close()
+
+	var id string
+	if err := b.Call(context.Background(), "Who.Name", 0, &id); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Call without replicas = %v, want ErrNotFound", err)
+	}
+
+	if err := f.Announce("Who", "127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Call(context.Background(), "Who.Name", 0, &id); !errors.Is(err, protocol.ErrUnavailable) {
+		t.Fatalf("Call with only unreachable replicas = %v, want ErrUnavailable", err)
+	}
+
+	// a deadline that passes while waiting for a connection is the caller's
+	fresh := balancer.New("Who", f) // one that does not skip the replica yet
+	defer fresh.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := fresh.Call(ctx, "Who.Name", 0, &id); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call past its deadline = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestBalancerClose(t *testing.T) {
+	f := registry.NewFile(filepath.Join(t.TempDir(), "registry.json"))
+	if err := f.Announce("Who", startReplica(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+	b := balancer.New("Who", f)
+	callN(t, b, 1)
+	b.Close()
+	var id string
+	if err := b.Call(context.Background(), "Who.Name", 0, &id); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("Call after Close = %v, want ErrShutdown", err)
+	}
+}
+
+// flakyResolver returns addrs until broken is set.
+type flakyResolver struct {
+	addrs  []string
+	broken atomic.Bool
+}
+
+func (r *flakyResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	if r.broken.Load() {
+		return nil, errors.New("registry down")
+	}
+	return r.addrs, nil
+}
+
+func TestBalancerKeepsEndpointsWhenResolveFails(t *testing.T) {
+	r := &flakyResolver{addrs: []string{startReplica(t, "a")}}
+	b := balancer.New("Who", r, balancer.WithRefreshInterval(time.Millisecond))
+	defer b.Close()
+
+	callN(t, b, 1)
+	r.broken.Store(true)
+	time.Sleep(5 * time.Millisecond)
+	if got := callN(t, b, 2); got["a"] != 2 {
+		t.Fatalf("calls with the registry down went %v", got)
+	}
+}
+
+// slowResolver blocks in Resolve, once stall is set, until release is
+// closed.
+type slowResolver struct {
+	addrs     []string
+	stall     atomic.Bool
+	resolving chan struct{}
+	release   chan struct{}
+}
+
+func (r *slowResolver) Resolve(ctx context.Context, service string) ([]string, error) {
+	if r.stall.Load() {
+		r.resolving <- struct{}{}
+		<-r.release
+	}
+	return r.addrs, nil
+}
+
+func TestBalancerResolvesWithoutLock(t *testing.T) {
+	r := &slowResolver{
+		addrs:     []string{startReplica(t, "a")},
+		resolving: make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	b := balancer.New("Who", r, balancer.WithRefreshInterval(time.Millisecond))
+	callN(t, b, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	r.stall.Store(true)
+	stuck := make(chan error, 1)
+	go func() {
+		var id string
+		stuck <- b.Call(context.Background(), "Who.Name", 0, &id)
+	}()
+	<-r.resolving
+
+	// Close does not wait for a slow resolver
+	closed := make(chan struct{})
+	go func() {
+		b.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the resolver")
+	}
+
+	close(r.release)
+	if err := <-stuck; !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("Call resolving across Close = %v, want ErrShutdown", err)
+	}
+}
+
+func TestBalancerRemoteLeastOutstanding(t *testing.T) {
+	rs := server.NewServer()
+	if err := rs.Register(registry.ServiceName, registry.NewService()); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := client.Dial("tcp", serveRegistry(t, rs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	reg := registry.NewRemote(rc)
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b"} {
+		if err := reg.Announce(ctx, "Who", startReplica(t, id), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := balancer.New("Who", reg,
+		balancer.WithPolicy(balancer.LeastOutstanding()),
+		balancer.WithClientOptions(client.WithCodec("binary")),
+	)
+	defer b.Close()
+
+	// a slow call keeps one replica busy, so the quick ones go to the other
+	var slow string
+	slowDone := make(chan error, 1)
+	go func() { slowDone <- b.Call(ctx, "Who.Name", 200, &slow) }()
+	time.Sleep(50 * time.Millisecond)
+
+	count := make(map[string]int)
+	for range 5 {
+		var id string
+		if err := b.Call(ctx, "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+		count[id]++
+	}
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+	if count[slow] != 0 {
+		t.Fatalf("quick calls went %v while %s was busy", count, slow)
+	}
+}
+
+func serveRegistry(t *testing.T, s *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
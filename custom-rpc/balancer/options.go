@@ -0,0 +1,32 @@
+package balancer
+
+import (
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+)
+
+type Option func(*Balancer)
+
+// WithPolicy sets how endpoints are chosen. The default is RoundRobin.
+func WithPolicy(p Policy) Option {
+	return func(b *Balancer) {
+		b.policy = p
+	}
+}
+
+// WithClientOptions sets the options every endpoint's client.Pool is
+// created with, such as client.WithCodec or client.WithPoolSize.
+func WithClientOptions(opts ...client.Option) Option {
+	return func(b *Balancer) {
+		b.clientOpts = append(b.clientOpts, opts...)
+	}
+}
+
+// WithRefreshInterval sets how long a resolved endpoint list is used before
+// the service name is resolved again. The default is 10 seconds.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(b *Balancer) {
+		b.refresh = d
+	}
+}
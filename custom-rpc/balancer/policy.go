@@ -0,0 +1,46 @@
+package balancer
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Policy chooses the endpoint for the next call. Pick is only given
+// endpoints that are believed to be reachable, and at least one of them;
+// it may be called from many goroutines at once.
+type Policy interface {
+	Pick(endpoints []*Endpoint) *Endpoint
+}
+
+// RoundRobin hands out endpoints in turn.
+func RoundRobin() Policy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (p *roundRobin) Pick(endpoints []*Endpoint) *Endpoint {
+	return endpoints[(p.next.Add(1)-1)%uint64(len(endpoints))]
+}
+
+// LeastOutstanding picks the endpoint with the fewest calls in flight, so
+// slow replicas get less work. Ties are broken at random.
+func LeastOutstanding() Policy {
+	return leastOutstanding{}
+}
+
+type leastOutstanding struct{}
+
+func (leastOutstanding) Pick(endpoints []*Endpoint) *Endpoint {
+	start := rand.IntN(len(endpoints))
+	best := endpoints[start]
+	for i := 1; i < len(endpoints); i++ {
+		ep := endpoints[(start+i)%len(endpoints)]
+		if ep.Outstanding() < best.Outstanding() {
+			best = ep
+		}
+	}
+	return best
+}
@@ -0,0 +1,66 @@
+package balancer
+
+import "testing"
+
+func endpoints(outstanding ...int64) []*Endpoint {
+	eps := make([]*Endpoint, len(outstanding))
+	for i, n := range outstanding {
+		eps[i] = &Endpoint{Addr: string(rune('a' + i))}
+		eps[i].outstanding.Add(n)
+	}
+	return eps
+}
+
+func TestRoundRobin(t *testing.T) {
+	eps := endpoints(0, 0, 0)
+	p := RoundRobin()
+	var got string
+	for range 7 {
+		got += p.Pick(eps).Addr
+	}
+	if got != "abcabca" {
+		t.Fatalf("picks = %q, want %q", got, "abcabca")
+	}
+
+	// a shrinking list never indexes out of range
+	if ep := p.Pick(eps[:1]); ep != eps[0] {
+		t.Fatalf("pick from one endpoint = %s", ep.Addr)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	p := LeastOutstanding()
+	tests := []struct {
+		outstanding []int64
+		want        string // the endpoints that may be picked
+	}{
+		{[]int64{3, 1, 2}, "b"},
+		{[]int64{0}, "a"},
+		{[]int64{5, 0, 0, 9}, "bc"},
+	}
+	for _, tt := range tests {
+		eps := endpoints(tt.outstanding...)
+		seen := make(map[string]bool)
+		for range 100 {
+			seen[p.Pick(eps).Addr] = true
+		}
+		for addr := range seen {
+			if !containsRune(tt.want, addr) {
+				t.Errorf("with outstanding %v picked %s, want one of %q", tt.outstanding, addr, tt.want)
+			}
+		}
+		// ties are broken at random, so every tied endpoint gets picked
+		if len(seen) != len(tt.want) {
+			t.Errorf("with outstanding %v picked %v, want each of %q", tt.outstanding, seen, tt.want)
+		}
+	}
+}
+
+func containsRune(s, r string) bool {
+	for _, c := range s {
+		if string(c) == r {
+			return true
+		}
+	}
+	return false
+}
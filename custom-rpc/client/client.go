@@ -0,0 +1,342 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// invalidBody is sent as the body of frames that carry none.
+var invalidBody = struct{}{}
+
+// ErrShutdown is returned for calls made on, or pending in, a client whose
+// connection has been closed or has failed.
+var ErrShutdown = errors.New("connection is shut down")
+
+// Call is a pending or completed call. Done receives the call itself once
+// Error and Reply are final.
+type Call struct {
+	ServiceMethod string
+	Args          any
+	Reply         any
+	Error         error
+	Done          chan *Call
+
+	seq      uint64
+	deadline time.Time
+	metadata map[string]string
+	finished chan struct{} // closed by done
+}
+
+// done must be called exactly once, by whoever removed the call from
+// Client.pending.
+func (call *Call) done() {
+	close(call.finished)
+	select {
+	case call.Done <- call:
+	default:
+		// the caller sized Done too small; never block the reader for it
+		slog.Warn("discarding call reply due to insufficient Done chan capacity", "method", call.ServiceMethod)
+	}
+}
+
+// Client multiplexes calls from any number of goroutines over a single
+// connection. Responses are matched to calls by sequence number, so they
+// may arrive in any order.
+type Client struct {
+	codec protocol.Codec
+	opts  *options
+
+	sending sync.Mutex    // serializes writes to codec
+	done    chan struct{} // closed once the connection is gone, for Pool
+
+	mu       sync.Mutex // guards the fields below
+	seq      uint64
+	pending  map[uint64]*Call
+	streams  map[uint64]*stream
+	closing  bool  // Close has been called
+	shutdown bool  // the connection has failed
+	err      error // why the connection failed
+}
+
+func Dial(network, address string, opts ...Option) (*Client, error) {
+	var conn net.Conn
+	var err error
+	if config := newOptions(opts).tls; config != nil {
+		conn, err = tls.Dial(network, address, config)
+	} else {
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	c, err := NewClient(conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient negotiates a codec on conn and starts reading responses.
+func NewClient(conn net.Conn, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+	codec, err := protocol.ClientHandshake(conn, o.codec)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithCodec(codec, opts...), nil
+}
+
+// NewClientWithCodec uses an already negotiated codec; WithCodec is
+// ignored.
+func NewClientWithCodec(codec protocol.Codec, opts ...Option) *Client {
+	c := &Client{
+		codec:   codec,
+		opts:    newOptions(opts),
+		pending: make(map[uint64]*Call),
+		streams: make(map[uint64]*stream),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Go invokes the method asynchronously. args must be a non-nil value of the
+// method's Args type and reply a pointer to its Reply type. The returned
+// Call is sent on done when it completes; if done is nil a new buffered
+// channel is allocated.
+//
+// ctx's deadline travels with the request and bounds the handler on the
+// server. If ctx is done first, the server is told to abort the call and
+// the call completes with ctx.Err().
+func (c *Client) Go(ctx context.Context, serviceMethod string, args, reply any, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		panic("client: done channel is unbuffered")
+	}
+	if len(c.opts.unaryInterceptors) == 0 {
+		return c.start(ctx, serviceMethod, args, reply, done)
+	}
+
+	// interceptors wrap a synchronous call, so run the chain on the side
+	call := newCall(serviceMethod, args, reply, done)
+	go func() {
+		call.Error = chainUnary(c.opts.unaryInterceptors, c.invoke)(ctx, serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
+
+// Call invokes the method and waits for it to complete or for ctx to be done.
+// Failures reported by the server come back as *protocol.Error, so they
+// can be told apart with errors.Is(err, protocol.ErrNotFound) and the like.
+func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+	return chainUnary(c.opts.unaryInterceptors, c.invoke)(ctx, serviceMethod, args, reply)
+}
+
+// invoke is the Invoker at the end of every interceptor chain.
+func (c *Client) invoke(ctx context.Context, serviceMethod string, args, reply any) error {
+	call := <-c.start(ctx, serviceMethod, args, reply, make(chan *Call, 1)).Done
+	return call.Error
+}
+
+func newCall(serviceMethod string, args, reply any, done chan *Call) *Call {
+	return &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+		finished:      make(chan struct{}),
+	}
+}
+
+// start sends the call and arranges for ctx to cancel it.
+func (c *Client) start(ctx context.Context, serviceMethod string, args, reply any, done chan *Call) *Call {
+	call := newCall(serviceMethod, args, reply, done)
+	if err := ctx.Err(); err != nil {
+		call.Error = err
+		call.done()
+		return call
+	}
+	call.deadline, _ = ctx.Deadline()
+	call.metadata = outgoingMetadata(ctx)
+	c.send(call)
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.cancel(call, ctx.Err())
+			case <-call.finished:
+			}
+		}()
+	}
+	return call
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return ErrShutdown
+	}
+	c.closing = true
+	c.mu.Unlock()
+	return c.codec.Close()
+}
+
+func (c *Client) send(call *Call) {
+	c.mu.Lock()
+	if c.closing || c.shutdown {
+		call.Error = c.shutdownError()
+		c.mu.Unlock()
+		call.done()
+		return
+	}
+	seq := c.seq
+	c.seq++
+	call.seq = seq
+	c.pending[seq] = call
+	c.mu.Unlock()
+
+	req := &protocol.Request{
+		Kind:          protocol.KindCall,
+		ServiceMethod: call.ServiceMethod,
+		Seq:           seq,
+		Metadata:      call.metadata,
+	}
+	if !call.deadline.IsZero() {
+		req.Deadline = call.deadline.UnixNano()
+	}
+	if err := c.write(req, call.Args); err != nil {
+		c.mu.Lock()
+		call = c.pending[seq]
+		delete(c.pending, seq)
+		c.mu.Unlock()
+		if call != nil {
+			call.Error = fmt.Errorf("failed to send request: %w", err)
+			call.done()
+		}
+	}
+}
+
+// cancel completes a call whose context is done and asks the server to
+// abort it. A reply that arrives later is discarded by read.
+func (c *Client) cancel(call *Call, err error) {
+	c.mu.Lock()
+	if c.pending[call.seq] != call {
+		c.mu.Unlock()
+		return // completed or failed in the meantime
+	}
+	delete(c.pending, call.seq)
+	c.mu.Unlock()
+
+	c.sendCancel(call.ServiceMethod, call.seq)
+	call.Error = err
+	call.done()
+}
+
+func (c *Client) sendCancel(serviceMethod string, seq uint64) {
+	err := c.write(&protocol.Request{
+		Kind:          protocol.KindCancel,
+		ServiceMethod: serviceMethod,
+		Seq:           seq,
+	}, invalidBody)
+	if err != nil {
+		slog.Warn("failed to send cancel", "method", serviceMethod, "error", err)
+	}
+}
+
+func (c *Client) write(req *protocol.Request, body any) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.codec.WriteRequest(req, body)
+}
+
+func (c *Client) read() {
+	var err error
+	for err == nil {
+		var resp protocol.Response
+		if err = c.codec.ReadResponseHeader(&resp); err != nil {
+			break
+		}
+		switch resp.Kind {
+		case protocol.KindCall:
+			err = c.readReply(&resp)
+		case protocol.KindStreamMsg:
+			err = c.readStreamMsg(&resp)
+		case protocol.KindStreamEnd:
+			err = c.readStreamEnd(&resp)
+		case protocol.KindWindowUpdate:
+			err = c.readWindowUpdate(&resp)
+		default:
+			slog.Warn("skipping unknown response kind", "kind", resp.Kind, "seq", resp.Seq)
+			err = c.codec.ReadResponseBody(nil)
+		}
+	}
+
+	// the connection is gone; fail everything still waiting on it
+	c.mu.Lock()
+	c.shutdown = true
+	if !c.closing && !errors.Is(err, io.EOF) {
+		c.err = err
+	}
+	shutdownErr := c.shutdownError()
+	for seq, call := range c.pending {
+		delete(c.pending, seq)
+		call.Error = shutdownErr
+		call.done()
+	}
+	for seq, st := range c.streams {
+		delete(c.streams, seq)
+		st.finish(shutdownErr, shutdownErr)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// readReply completes the unary call the response belongs to. It only
+// returns an error when the connection can no longer be read.
+func (c *Client) readReply(resp *protocol.Response) error {
+	c.mu.Lock()
+	call := c.pending[resp.Seq]
+	delete(c.pending, resp.Seq)
+	c.mu.Unlock()
+
+	switch {
+	case call == nil:
+		// the caller gave up on this one; skip its reply
+		return c.codec.ReadResponseBody(nil)
+	case resp.Err() != nil:
+		call.Error = resp.Err()
+		err := c.codec.ReadResponseBody(nil)
+		call.done()
+		return err
+	default:
+		// codecs read whole messages, so a reply of the wrong type only
+		// fails this call
+		if err := c.codec.ReadResponseBody(call.Reply); err != nil {
+			call.Error = fmt.Errorf("failed to decode reply: %w", err)
+		}
+		call.done()
+		return nil
+	}
+}
+
+// shutdownError must be called with c.mu held.
+func (c *Client) shutdownError() error {
+	if c.err != nil {
+		return fmt.Errorf("%w: %w", ErrShutdown, c.err)
+	}
+	return ErrShutdown
+}
@@ -0,0 +1,293 @@
+package client_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+type echo struct{}
+
+func (echo) Upper(ctx context.Context, s *string, reply *string) error {
+	*reply = *s + "!"
+	return nil
+}
+
+func (echo) Sleep(ctx context.Context, ms *int, reply *int) error {
+	time.Sleep(time.Duration(*ms) * time.Millisecond)
+	*reply = *ms
+	return nil
+}
+
+func (echo) Fail(ctx context.Context, s *string, reply *string) error {
+	return errors.New("failed on purpose")
+}
+
+// Find fails with a coded error, wrapped on the way out.
+func (echo) Find(ctx context.Context, key *string, reply *string) error {
+	err := &protocol.Error{Code: protocol.CodeNotFound, Message: "no such key", Details: []byte(*key)}
+	return fmt.Errorf("looking up %s: %w", *key, err)
+}
+
+// startServer serves echo and returns its address.
+func startServer(t *testing.T) string {
+	t.Helper()
+	s := server.NewServer()
+	if err := s.Register("Echo", echo{}); err != nil {
+		t.Fatal(err)
+	}
+	return serve(t, s)
+}
+
+// serve runs s on a loopback listener for the length of the test and
+// returns its address.
+func serve(t *testing.T, s *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client.Client {
+	t.Helper()
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCall(t *testing.T) {
+	c := dial(t, startServer(t))
+
+	var reply string
+	if err := c.Call(context.Background(), "Echo.Upper", "hi", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hi!" {
+		t.Fatalf("reply = %q, want %q", reply, "hi!")
+	}
+
+	err := c.Call(context.Background(), "Echo.Fail", "x", &reply)
+	// a plain error from the handler keeps its text and gets CodeUnknown
+	var pe *protocol.Error
+	if !errors.As(err, &pe) || pe.Code != protocol.CodeUnknown || pe.Message != "failed on purpose" {
+		t.Fatalf("err = %v, want an unknown *protocol.Error %q", err, "failed on purpose")
+	}
+	if err := c.Call(context.Background(), "Echo.Nope", "x", &reply); !errors.Is(err, protocol.ErrUnimplemented) {
+		t.Fatalf("unknown method: err = %v, want ErrUnimplemented", err)
+	}
+	if err := c.Call(context.Background(), "Echo.Upper", 1, &reply); !errors.Is(err, protocol.ErrInvalidArgument) {
+		t.Fatalf("wrong args type: err = %v, want ErrInvalidArgument", err)
+	}
+
+	var n int
+	if err := c.Call(context.Background(), "Echo.Upper", "x", &n); err == nil {
+		t.Fatal("storing a string reply in an int succeeded")
+	}
+}
+
+// TestMultiplexing checks that a slow call does not hold up the calls
+// behind it on the same connection.
+func TestMultiplexing(t *testing.T) {
+	c := dial(t, startServer(t))
+
+	var slow int
+	slowCall := c.Go(context.Background(), "Echo.Sleep", 300, &slow, nil)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := c.Call(context.Background(), "Echo.Sleep", i, &n); err != nil || n != i {
+				t.Errorf("Sleep(%d) = %d, %v", i, n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-slowCall.Done:
+		t.Fatal("the slow call finished before the fast ones")
+	default:
+	}
+	<-slowCall.Done
+	if slowCall.Error != nil || slow != 300 {
+		t.Fatalf("slow call = %d, %v", slow, slowCall.Error)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	c := dial(t, startServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var n int
+	if err := c.Call(ctx, "Echo.Sleep", 300, &n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	// the late reply is dropped, and the connection keeps working
+	var s string
+	if err := c.Call(context.Background(), "Echo.Upper", "a", &s); err != nil || s != "a!" {
+		t.Fatalf("after a canceled call: %q, %v", s, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := dial(t, startServer(t))
+
+	var n int
+	pending := c.Go(context.Background(), "Echo.Sleep", 200, &n, nil)
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-pending.Done
+	if !errors.Is(pending.Error, client.ErrShutdown) {
+		t.Fatalf("pending call: err = %v, want ErrShutdown", pending.Error)
+	}
+	if err := c.Call(context.Background(), "Echo.Sleep", 1, &n); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("call after Close: err = %v, want ErrShutdown", err)
+	}
+	if err := c.Close(); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("second Close: err = %v, want ErrShutdown", err)
+	}
+}
+
+func TestServerHangsUp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// agree to the handshake, then drop the connection mid-call
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "OK\n")
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}()
+
+	c := dial(t, ln.Addr().String())
+	var s string
+	if err := c.Call(context.Background(), "Echo.Upper", "a", &s); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("err = %v, want ErrShutdown", err)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	addr := startServer(t)
+	for _, name := range protocol.CodecNames() {
+		t.Run(name, func(t *testing.T) {
+			c, err := client.Dial("tcp", addr, client.WithCodec(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			var reply string
+			if err := c.Call(context.Background(), "Echo.Upper", "hi", &reply); err != nil || reply != "hi!" {
+				t.Fatalf("Upper = %q, %v", reply, err)
+			}
+			err = c.Call(context.Background(), "Echo.Find", "key", &reply)
+			var pe *protocol.Error
+			if !errors.Is(err, protocol.ErrNotFound) || !errors.As(err, &pe) || string(pe.Details) != "key" {
+				t.Fatalf("Find: err = %v, want ErrNotFound with details %q", err, "key")
+			}
+		})
+	}
+
+	if _, err := client.Dial("tcp", addr, client.WithCodec("xml")); err == nil {
+		t.Fatal("dialing with an unknown codec succeeded")
+	}
+}
+
+// waiter blocks until the call's context is done and reports why.
+type waiter struct{ stopped chan error }
+
+func (w waiter) Wait(ctx context.Context, args *int, reply *int) error {
+	<-ctx.Done()
+	w.stopped <- ctx.Err()
+	return ctx.Err()
+}
+
+func startWaiter(t *testing.T) (*client.Client, chan error) {
+	t.Helper()
+	w := waiter{stopped: make(chan error, 1)}
+	s := server.NewServer()
+	if err := s.Register("Waiter", w); err != nil {
+		t.Fatal(err)
+	}
+	return dial(t, serve(t, s)), w.stopped
+}
+
+func TestDeadlinePropagates(t *testing.T) {
+	c, stopped := startWaiter(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var n int
+	if err := c.Call(ctx, "Waiter.Wait", 1, &n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("handler stopped with %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler outlived the caller's deadline")
+	}
+}
+
+func TestCancelAbortsHandler(t *testing.T) {
+	c, stopped := startWaiter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var n int
+	call := c.Go(ctx, "Waiter.Wait", 1, &n, nil)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-call.Done
+	if !errors.Is(call.Error, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", call.Error)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("handler stopped with %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the cancel frame did not reach the handler")
+	}
+}
+
+func TestCallWithDoneContext(t *testing.T) {
+	c := dial(t, startServer(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var s string
+	if err := c.Call(ctx, "Echo.Upper", "a", &s); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import "context"
+
+// Invoker performs a unary call, or runs the next interceptor in line.
+type Invoker func(ctx context.Context, method string, args, reply any) error
+
+// UnaryInterceptor runs around every unary call. It sees the method name,
+// the args and the reply to be filled in. It may call invoker, possibly
+// with a different context (for example one carrying more metadata), or
+// return without calling it to short-circuit the call.
+type UnaryInterceptor func(ctx context.Context, method string, args, reply any, invoker Invoker) error
+
+// ClientStream is the untyped view of a Stream that stream interceptors
+// see. An interceptor may wrap it to observe or alter the messages; the
+// caller's Stream reads and writes through the wrapper.
+type ClientStream interface {
+	Context() context.Context
+	SendMsg(msg any) error
+	RecvMsg() (any, error)
+	CloseSend() error
+}
+
+// Streamer opens a stream, or runs the next interceptor in line.
+type Streamer func(ctx context.Context, method string, args any) (ClientStream, error)
+
+// StreamInterceptor runs around every OpenStream, like UnaryInterceptor
+// does around unary calls.
+type StreamInterceptor func(ctx context.Context, method string, args any, streamer Streamer) (ClientStream, error)
+
+// chainUnary wraps final in the interceptors, the first one outermost.
+func chainUnary(interceptors []UnaryInterceptor, final Invoker) Invoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, args, reply any) error {
+			return interceptor(ctx, method, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// chainStream wraps final in the interceptors, the first one outermost.
+func chainStream(interceptors []StreamInterceptor, final Streamer) Streamer {
+	streamer := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], streamer
+		streamer = func(ctx context.Context, method string, args any) (ClientStream, error) {
+			return interceptor(ctx, method, args, next)
+		}
+	}
+	return streamer
+}
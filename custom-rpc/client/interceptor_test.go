@@ -0,0 +1,212 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+// trace records the order in which interceptors run.
+type trace struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (tr *trace) add(event string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.events = append(tr.events, event)
+}
+
+func (tr *trace) take() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	events := tr.events
+	tr.events = nil
+	return events
+}
+
+func (tr *trace) serverUnary(name string) server.UnaryInterceptor {
+	return func(ctx context.Context, method string, args, reply any, next server.UnaryHandler) error {
+		tr.add("server " + name + " " + server.Metadata(ctx)["via"])
+		err := next(ctx, args, reply)
+		tr.add("server " + name + " done")
+		return err
+	}
+}
+
+func (tr *trace) clientUnary(name string) client.UnaryInterceptor {
+	return func(ctx context.Context, method string, args, reply any, invoker client.Invoker) error {
+		tr.add("client " + name)
+		err := invoker(client.AppendMetadata(ctx, "via", name), method, args, reply)
+		tr.add("client " + name + " done")
+		return err
+	}
+}
+
+func TestUnaryInterceptorOrder(t *testing.T) {
+	tr := new(trace)
+	s := server.NewServer(server.WithUnaryInterceptors(tr.serverUnary("outer"), tr.serverUnary("inner")))
+	if err := s.Register("Echo", echo{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.Dial("tcp", serve(t, s), client.WithUnaryInterceptors(tr.clientUnary("a"), tr.clientUnary("b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var reply string
+	if err := c.Call(context.Background(), "Echo.Upper", "x", &reply); err != nil || reply != "x!" {
+		t.Fatalf("Call = %q, %v", reply, err)
+	}
+	want := []string{
+		"client a", "client b",
+		// metadata set by the innermost client interceptor wins
+		"server outer b", "server inner b",
+		"server inner done", "server outer done",
+		"client b done", "client a done",
+	}
+	if got := tr.take(); !slices.Equal(got, want) {
+		t.Fatalf("interceptors ran as\n%q\nwant\n%q", got, want)
+	}
+
+	// Go runs the same chain
+	call := <-c.Go(context.Background(), "Echo.Upper", "y", &reply, nil).Done
+	if call.Error != nil || reply != "y!" {
+		t.Fatalf("Go = %q, %v", reply, call.Error)
+	}
+	if got := tr.take(); !slices.Equal(got, want) {
+		t.Fatalf("interceptors ran as\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestUnaryInterceptorShortCircuit(t *testing.T) {
+	errDenied := errors.New("denied")
+	deny := func(ctx context.Context, method string, args, reply any, next server.UnaryHandler) error {
+		if method == "Echo.Fail" {
+			return errDenied
+		}
+		return next(ctx, args, reply)
+	}
+	s := server.NewServer(server.WithUnaryInterceptors(deny))
+	if err := s.Register("Echo", echo{}); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, serve(t, s))
+
+	var reply string
+	var pe *protocol.Error
+	if err := c.Call(context.Background(), "Echo.Fail", "x", &reply); !errors.As(err, &pe) || pe.Message != "denied" {
+		t.Fatalf("err = %v, want a *protocol.Error %q", err, "denied")
+	}
+	if err := c.Call(context.Background(), "Echo.Upper", "x", &reply); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type panicky struct{}
+
+func (panicky) Boom(ctx context.Context, args *int, reply *int) error { panic("boom") }
+
+func TestRecoveryUnaryInterceptor(t *testing.T) {
+	var seen error
+	observe := func(ctx context.Context, method string, args, reply any, next server.UnaryHandler) error {
+		seen = next(ctx, args, reply)
+		return seen
+	}
+	s := server.NewServer(server.WithUnaryInterceptors(observe, server.RecoveryUnaryInterceptor))
+	if err := s.Register("Panicky", panicky{}); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, serve(t, s))
+
+	var n int
+	if err := c.Call(context.Background(), "Panicky.Boom", 1, &n); !errors.Is(err, protocol.ErrInternal) {
+		t.Fatalf("err = %v, want ErrInternal", err)
+	}
+	// recovery sits inside observe, so observe saw an error, not a panic
+	if !errors.Is(seen, protocol.ErrInternal) {
+		t.Fatalf("outer interceptor saw %v", seen)
+	}
+}
+
+// countingStream counts the messages the handler sends.
+type countingStream struct {
+	server.ServerStream
+	sent *int
+}
+
+func (s countingStream) SendMsg(msg any) error {
+	*s.sent++
+	return s.ServerStream.SendMsg(msg)
+}
+
+// upperStream changes every message the caller sends.
+type upperStream struct {
+	client.ClientStream
+}
+
+func (s upperStream) SendMsg(msg any) error {
+	m := *msg.(*string) + "?"
+	return s.ClientStream.SendMsg(&m)
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	tr := new(trace)
+	sent := 0
+	serverSide := func(ctx context.Context, method string, args any, stream server.ServerStream, next server.StreamHandler) error {
+		tr.add("server " + method + " " + server.Metadata(ctx)["via"])
+		return next(ctx, args, countingStream{ServerStream: stream, sent: &sent})
+	}
+	clientSide := func(ctx context.Context, method string, args any, streamer client.Streamer) (client.ClientStream, error) {
+		tr.add("client " + method)
+		cs, err := streamer(client.AppendMetadata(ctx, "via", "client"), method, args)
+		if err != nil {
+			return nil, err
+		}
+		return upperStream{ClientStream: cs}, nil
+	}
+
+	s := server.NewServer(server.WithStreamInterceptors(serverSide))
+	if err := s.Register("Streamer", streamer{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.Dial("tcp", serve(t, s), client.WithStreamInterceptors(clientSide))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	stream, err := client.OpenStream[string, string](context.Background(), c, "Streamer.Echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b"} {
+		if err := stream.Send(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := stream.Recv(); err != nil || *got != msg+"?!" {
+			t.Fatalf("Recv = %v, %v; want %q", got, err, msg+"?!")
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv = %v, want io.EOF", err)
+	}
+
+	want := []string{"client Streamer.Echo", "server Streamer.Echo client"}
+	if got := tr.take(); !slices.Equal(got, want) {
+		t.Fatalf("interceptors ran as %q, want %q", got, want)
+	}
+	if sent != 2 {
+		t.Fatalf("the server interceptor counted %d sent messages, want 2", sent)
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"maps"
+)
+
+type metadataKey struct{}
+
+// AppendMetadata returns a copy of ctx whose calls carry the given key/value
+// pairs to the server, in addition to any already attached. kv must hold
+// an even number of strings.
+func AppendMetadata(ctx context.Context, kv ...string) context.Context {
+	if len(kv)%2 != 0 {
+		panic("client: AppendMetadata got an odd number of key/value strings")
+	}
+	md := maps.Clone(outgoingMetadata(ctx))
+	if md == nil {
+		md = make(map[string]string, len(kv)/2)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+func outgoingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
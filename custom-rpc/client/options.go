@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+type options struct {
+	codec              string
+	tls                *tls.Config
+	unaryInterceptors  []UnaryInterceptor
+	streamInterceptors []StreamInterceptor
+
+	// used by Pool only
+	poolSize   int
+	backoff    Backoff
+	maxRetries int
+}
+
+type Option func(*options)
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		codec:      protocol.DefaultCodec,
+		poolSize:   2,
+		backoff:    Backoff{Base: 100 * time.Millisecond, Max: 10 * time.Second},
+		maxRetries: 3,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCodec selects the wire codec to negotiate with the server, one of
+// protocol.CodecNames. The default is protocol.DefaultCodec.
+func WithCodec(name string) Option {
+	return func(o *options) {
+		o.codec = name
+	}
+}
+
+// WithTLS makes Dial, and so Pool, connect over TLS with config. For
+// mutual TLS, put the client's certificate in config.Certificates.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tls = config
+	}
+}
+
+// WithUnaryInterceptors adds interceptors that run around every Call and
+// Go, in the order given.
+func WithUnaryInterceptors(interceptors ...UnaryInterceptor) Option {
+	return func(o *options) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds interceptors that run around every
+// OpenStream, in the order given.
+func WithStreamInterceptors(interceptors ...StreamInterceptor) Option {
+	return func(o *options) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithPoolSize sets how many connections a Pool keeps to its address. The
+// default is 2.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		o.poolSize = max(n, 1)
+	}
+}
+
+// WithBackoff sets the delays a Pool waits between redials and between
+// retries. The default grows from 100ms to 10s.
+func WithBackoff(b Backoff) Option {
+	return func(o *options) {
+		o.backoff = b
+	}
+}
+
+// WithMaxRetries sets how many times a Pool retries a failed call to an
+// idempotent method. The default is 3; zero disables retries.
+func WithMaxRetries(n int) Option {
+	return func(o *options) {
+		o.maxRetries = max(n, 0)
+	}
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// ErrNoConnection is returned by a Pool that has no working connection to
+// its address. The call was not sent, so it is safe to try elsewhere.
+var ErrNoConnection = errors.New("no connection available")
+
+// Backoff computes exponentially growing delays with jitter.
+type Backoff struct {
+	Base time.Duration // delay before the first retry
+	Max  time.Duration // cap on the delay
+}
+
+// Delay returns the delay before retry number attempt, counted from zero:
+// somewhere between half and all of Base doubled attempt times, capped at
+// Max.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Pool keeps several connections to one address and spreads calls over
+// them. Connections that drop are redialed in the background with
+// exponential backoff. Calls to methods the server marked idempotent are
+// retried when they fail for reasons that a new attempt may fix.
+type Pool struct {
+	network string
+	addr    string
+	opts    []Option
+	o       *options
+	done    chan struct{} // closed by Close
+
+	mu         sync.Mutex
+	slots      []*slot
+	next       int
+	changed    chan struct{}   // closed and replaced whenever a slot changes
+	idempotent map[string]bool // nil until learned from RPC.ListMethods
+	closed     bool
+}
+
+// slot is one connection of the pool, maintained by its own goroutine.
+type slot struct {
+	client *Client // nil while disconnected
+	err    error   // why the last dial failed
+}
+
+// NewPool starts dialing the address right away; calls made before a
+// connection is up wait for it. WithCodec and the interceptor options
+// apply to every connection.
+func NewPool(network, addr string, opts ...Option) *Pool {
+	o := newOptions(opts)
+	p := &Pool{
+		network: network,
+		addr:    addr,
+		opts:    opts,
+		o:       o,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	for i := 0; i < o.poolSize; i++ {
+		s := &slot{}
+		p.slots = append(p.slots, s)
+		go p.maintain(s)
+	}
+	return p
+}
+
+// maintain keeps s connected until the pool is closed.
+func (p *Pool) maintain(s *slot) {
+	for attempt := 0; ; {
+		c, err := Dial(p.network, p.addr, p.opts...)
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		s.client, s.err = c, err
+		p.notify()
+		p.mu.Unlock()
+
+		if err != nil {
+			delay := p.o.backoff.Delay(attempt)
+			attempt++
+			slog.Warn("failed to dial; retrying", "addr", p.addr, "in", delay, "error", err)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-p.done:
+				return
+			}
+		}
+		attempt = 0
+		// the server may have been replaced by one with other methods
+		p.learnMethods(c)
+
+		select {
+		case <-c.done:
+		case <-p.done:
+			return
+		}
+		p.mu.Lock()
+		s.client = nil
+		p.notify()
+		p.mu.Unlock()
+		slog.Warn("connection lost; redialing", "addr", p.addr)
+	}
+}
+
+// learnMethods asks the server which methods are idempotent. If it
+// cannot tell, what was learned before is kept.
+func (p *Pool) learnMethods(c *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var reply protocol.ListMethodsReply
+	if err := c.Call(ctx, protocol.ReflectionService+".ListMethods", &protocol.ListMethodsArgs{}, &reply); err != nil {
+		slog.Warn("failed to list methods; not retrying calls", "addr", p.addr, "error", err)
+		return
+	}
+	idempotent := make(map[string]bool)
+	for _, m := range reply.Methods {
+		if m.Idempotent {
+			idempotent[m.Name] = true
+		}
+	}
+	p.mu.Lock()
+	p.idempotent = idempotent
+	p.mu.Unlock()
+}
+
+// notify must be called with p.mu held.
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Get returns one of the pool's connections, for OpenStream and the like.
+// While there is none, it waits for the dials, which are retried with the
+// backoff, until ctx is done. It then fails with an error that matches
+// both ErrNoConnection and ctx's error.
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrShutdown
+		}
+		var lastErr error
+		for range p.slots {
+			s := p.slots[p.next%len(p.slots)]
+			p.next++
+			if s.client != nil {
+				p.mu.Unlock()
+				return s.client, nil
+			}
+			if s.err != nil {
+				lastErr = s.err
+			}
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		// dialing, or redialing after a failure or a dropped connection
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w: %w (last dial: %w)", ErrNoConnection, ctx.Err(), lastErr)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrNoConnection, ctx.Err())
+		}
+	}
+}
+
+// Call invokes the method on one of the pool's connections, like
+// Client.Call. If the method is idempotent and the call fails because the
+// connection dropped or the server was unavailable or overloaded, it is
+// retried after a backoff, as long as that is still before ctx's deadline.
+func (p *Pool) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+	for attempt := 0; ; attempt++ {
+		c, err := p.Get(ctx)
+		if err == nil {
+			err = c.Call(ctx, serviceMethod, args, reply)
+		}
+		if err == nil || attempt >= p.o.maxRetries || !retryable(err) || !p.isIdempotent(serviceMethod) {
+			return err
+		}
+
+		delay := p.o.backoff.Delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Info("retrying call", "method", serviceMethod, "attempt", attempt+1, "in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (p *Pool) isIdempotent(serviceMethod string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idempotent[serviceMethod]
+}
+
+// retryable reports whether err may go away on another attempt.
+func retryable(err error) bool {
+	if errors.Is(err, ErrShutdown) || errors.Is(err, ErrNoConnection) {
+		return true
+	}
+	switch protocol.CodeOf(err) {
+	case protocol.CodeUnavailable, protocol.CodeOverloaded:
+		return true
+	}
+	return false
+}
+
+// Close closes every connection; calls in flight fail with ErrShutdown.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrShutdown
+	}
+	p.closed = true
+	close(p.done)
+	var clients []*Client
+	for _, s := range p.slots {
+		if s.client != nil {
+			clients = append(clients, s.client)
+			s.client = nil
+		}
+	}
+	p.notify()
+	p.mu.Unlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+	return nil
+}
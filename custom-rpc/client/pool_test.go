@@ -0,0 +1,318 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+// flaky fails each method's first calls with CodeUnavailable.
+type flaky struct {
+	failures int64 // how many calls fail before one succeeds
+	calls    map[string]*atomic.Int64
+}
+
+func newFlaky(failures int64) *flaky {
+	return &flaky{failures: failures, calls: map[string]*atomic.Int64{
+		"Get":  new(atomic.Int64),
+		"Put":  new(atomic.Int64),
+		"Find": new(atomic.Int64),
+	}}
+}
+
+func (f *flaky) call(method string) error {
+	if f.calls[method].Add(1) <= f.failures {
+		return protocol.Errorf(protocol.CodeUnavailable, "try again")
+	}
+	return nil
+}
+
+func (f *flaky) Get(ctx context.Context, args *int, reply *int) error {
+	*reply = *args
+	return f.call("Get")
+}
+
+func (f *flaky) Put(ctx context.Context, args *int, reply *int) error {
+	return f.call("Put")
+}
+
+func (f *flaky) Find(ctx context.Context, args *int, reply *int) error {
+	f.calls["Find"].Add(1)
+	return protocol.ErrNotFound
+}
+
+func newPool(t *testing.T, addr string, opts ...client.Option) *client.Pool {
+	t.Helper()
+	opts = append([]client.Option{client.WithBackoff(client.Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond})}, opts...)
+	p := client.NewPool("tcp", addr, opts...)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPoolRetriesIdempotentCalls(t *testing.T) {
+	svc := newFlaky(2)
+	s := server.NewServer()
+	if err := s.Register("Flaky", svc, server.Idempotent("Get", "Find")); err != nil {
+		t.Fatal(err)
+	}
+	p := newPool(t, serve(t, s), client.WithMaxRetries(3))
+	ctx := context.Background()
+
+	var n int
+	learnIdempotent(t, svc, func() error { return p.Call(ctx, "Flaky.Get", 7, &n) })
+	if err := p.Call(ctx, "Flaky.Get", 7, &n); err != nil || n != 7 {
+		t.Fatalf("Get = %d, %v; want it to succeed on the third attempt", n, err)
+	}
+	if got := svc.calls["Get"].Load(); got != 3 {
+		t.Fatalf("Get was attempted %d times, want 3", got)
+	}
+
+	// not idempotent: the first failure is final
+	if err := p.Call(ctx, "Flaky.Put", 1, &n); !errors.Is(err, protocol.ErrUnavailable) {
+		t.Fatalf("Put = %v, want ErrUnavailable", err)
+	}
+	if got := svc.calls["Put"].Load(); got != 1 {
+		t.Fatalf("Put was attempted %d times, want 1", got)
+	}
+
+	// idempotent, but the error is not one a retry can fix
+	if err := p.Call(ctx, "Flaky.Find", 1, &n); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Find = %v, want ErrNotFound", err)
+	}
+	if got := svc.calls["Find"].Load(); got != 1 {
+		t.Fatalf("Find was attempted %d times, want 1", got)
+	}
+}
+
+func TestPoolGivesUpAfterMaxRetries(t *testing.T) {
+	svc := newFlaky(10)
+	s := server.NewServer()
+	if err := s.Register("Flaky", svc, server.Idempotent("Get")); err != nil {
+		t.Fatal(err)
+	}
+	p := newPool(t, serve(t, s), client.WithMaxRetries(2))
+
+	var n int
+	learnIdempotent(t, svc, func() error { return p.Call(context.Background(), "Flaky.Get", 1, &n) })
+	if err := p.Call(context.Background(), "Flaky.Get", 1, &n); !errors.Is(err, protocol.ErrUnavailable) {
+		t.Fatalf("Get = %v, want ErrUnavailable", err)
+	}
+	if got := svc.calls["Get"].Load(); got != 3 {
+		t.Fatalf("Get was attempted %d times, want 1 + 2 retries", got)
+	}
+}
+
+// learnIdempotent calls Flaky.Get through get until the pool retries it,
+// which it only does once it has learned the method is idempotent, and
+// then resets the call counter.
+func learnIdempotent(t *testing.T, svc *flaky, get func() error) {
+	t.Helper()
+	for range 100 {
+		svc.calls["Get"].Store(0)
+		get()
+		if svc.calls["Get"].Load() > 1 {
+			svc.calls["Get"].Store(0)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("the pool never learned that Flaky.Get is idempotent")
+}
+
+func TestPoolRedials(t *testing.T) {
+	s := server.NewServer()
+	if err := s.Register("Echo", echo{}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go s.ServeConn(conn)
+		}
+	}()
+
+	p := newPool(t, ln.Addr().String(), client.WithPoolSize(1))
+	var reply string
+	if err := p.Call(context.Background(), "Echo.Upper", "a", &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the connection from the server side
+	mu.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := p.Call(context.Background(), "Echo.Upper", "b", &reply)
+		if err == nil && reply == "b!" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool did not reconnect: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolUnreachable(t *testing.T) {
+	p := newPool(t, "127.0.0.1:1")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := p.Get(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, client.ErrNoConnection) {
+		t.Fatalf("Get = %v, want DeadlineExceeded and ErrNoConnection", err)
+	}
+}
+
+// serveAt serves s on addr until the test ends or stop is called.
+func serveAt(t *testing.T, s *server.Server, addr string) (stop func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	stop = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// freeAddr returns a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestPoolGetWaitsForServer(t *testing.T) {
+	addr := freeAddr(t)
+	p := newPool(t, addr)
+	got := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := p.Get(ctx)
+		got <- err
+	}()
+
+	// the first dials fail; Get keeps waiting while they are retried
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-got:
+		t.Fatalf("Get = %v before the server was up", err)
+	default:
+	}
+	s := server.NewServer()
+	if err := s.Register("Echo", echo{}); err != nil {
+		t.Fatal(err)
+	}
+	serveAt(t, s, addr)
+	if err := <-got; err != nil {
+		t.Fatalf("Get = %v, want a connection once the server is up", err)
+	}
+}
+
+func TestPoolRelearnsMethods(t *testing.T) {
+	addr := freeAddr(t)
+	before := newFlaky(100)
+	s := server.NewServer()
+	if err := s.Register("Flaky", before); err != nil {
+		t.Fatal(err)
+	}
+	stop := serveAt(t, s, addr)
+	p := newPool(t, addr, client.WithPoolSize(1), client.WithMaxRetries(3))
+
+	var n int
+	if err := p.Call(context.Background(), "Flaky.Get", 1, &n); !errors.Is(err, protocol.ErrUnavailable) {
+		t.Fatalf("Get = %v, want ErrUnavailable", err)
+	}
+	if got := before.calls["Get"].Load(); got != 1 {
+		t.Fatalf("Get was attempted %d times, want 1 while it is not idempotent", got)
+	}
+
+	// the server comes back with Get marked idempotent
+	stop()
+	after := newFlaky(2)
+	s = server.NewServer()
+	if err := s.Register("Flaky", after, server.Idempotent("Get")); err != nil {
+		t.Fatal(err)
+	}
+	serveAt(t, s, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	learnIdempotent(t, after, func() error { return p.Call(ctx, "Flaky.Get", 7, &n) })
+}
+
+func TestPoolClose(t *testing.T) {
+	p := newPool(t, startServer(t))
+	var reply string
+	if err := p.Call(context.Background(), "Echo.Upper", "a", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Call(context.Background(), "Echo.Upper", "a", &reply); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("Call after Close = %v, want ErrShutdown", err)
+	}
+	if err := p.Close(); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("second Close = %v, want ErrShutdown", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := client.Backoff{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 5 * time.Millisecond, 10 * time.Millisecond},
+		{1, 10 * time.Millisecond, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond, 80 * time.Millisecond},
+		{10, 50 * time.Millisecond, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if d := b.Delay(tt.attempt); d < tt.min || d > tt.max {
+				t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+	if d := (client.Backoff{}).Delay(3); d != 0 {
+		t.Fatalf("zero Backoff delays %v", d)
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/shahin-bayat/custom-rpc/internal/flow"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+var errSendClosed = errors.New("send side of the stream is closed")
+
+// stream is the untyped state of one open stream on a client.
+type stream struct {
+	client  *Client
+	method  string
+	seq     uint64
+	ctx     context.Context
+	send    *flow.SendWindow
+	recv    *flow.RecvQueue
+	newRecv func() any    // fresh *Recv to decode a server message into
+	done    chan struct{} // closed by finish
+
+	mu         sync.Mutex
+	sendClosed bool
+}
+
+// finish ends the stream locally. It must be called exactly once, by
+// whoever removed the stream from Client.streams.
+func (st *stream) finish(sendErr, recvErr error) {
+	st.send.Close(sendErr)
+	st.recv.Close(recvErr)
+	close(st.done)
+}
+
+func (st *stream) grant(n int) {
+	err := st.client.write(&protocol.Request{
+		Kind:          protocol.KindWindowUpdate,
+		ServiceMethod: st.method,
+		Seq:           st.seq,
+		Window:        uint32(n),
+	}, invalidBody)
+	if err != nil {
+		st.client.abortStream(st, fmt.Errorf("failed to send window update: %w", err))
+	}
+}
+
+// Stream is the caller's end of a streaming call. It sends Send messages
+// to the handler and receives Recv messages from it; the type parameters
+// mirror the handler's server.Stream[In, Out] as Stream[In, Out].
+type Stream[Send, Recv any] struct {
+	cs ClientStream
+}
+
+// OpenStream starts a streaming call. args is passed to handlers that take
+// them and may be nil for those that don't. ctx bounds the whole stream:
+// its deadline travels to the server and canceling it aborts the handler.
+func OpenStream[Send, Recv any](ctx context.Context, c *Client, serviceMethod string, args any) (*Stream[Send, Recv], error) {
+	streamer := func(ctx context.Context, serviceMethod string, args any) (ClientStream, error) {
+		st, err := c.openStream(ctx, serviceMethod, args, func() any { return new(Recv) })
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	}
+	cs, err := chainStream(c.opts.streamInterceptors, streamer)(ctx, serviceMethod, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream[Send, Recv]{cs: cs}, nil
+}
+
+func (s *Stream[Send, Recv]) Context() context.Context {
+	return s.cs.Context()
+}
+
+// Send queues msg for the handler, waiting while the handler has no room
+// for more messages. Once the handler has returned, Send fails with
+// io.EOF and Recv reports how the stream ended.
+func (s *Stream[Send, Recv]) Send(msg *Send) error {
+	return s.cs.SendMsg(msg)
+}
+
+// CloseSend tells the handler no more messages will come, so its Recv
+// returns io.EOF. Receiving keeps working.
+func (s *Stream[Send, Recv]) CloseSend() error {
+	return s.cs.CloseSend()
+}
+
+// Recv returns the next message from the handler. After the last one it
+// returns io.EOF if the handler succeeded, or the handler's error as a
+// *protocol.Error.
+func (s *Stream[Send, Recv]) Recv() (*Recv, error) {
+	msg, err := s.cs.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+	recv, ok := msg.(*Recv)
+	if !ok {
+		return nil, fmt.Errorf("stream message is %T, want %T", msg, recv)
+	}
+	return recv, nil
+}
+
+// openStream is the Streamer at the end of every interceptor chain.
+func (c *Client) openStream(ctx context.Context, serviceMethod string, args any, newRecv func() any) (*stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = invalidBody
+	}
+	st := &stream{
+		client:  c,
+		method:  serviceMethod,
+		ctx:     ctx,
+		send:    flow.NewSendWindow(protocol.InitialWindow),
+		newRecv: newRecv,
+		done:    make(chan struct{}),
+	}
+	st.recv = flow.NewRecvQueue(protocol.InitialWindow, st.grant)
+
+	c.mu.Lock()
+	if c.closing || c.shutdown {
+		err := c.shutdownError()
+		c.mu.Unlock()
+		return nil, err
+	}
+	st.seq = c.seq
+	c.seq++
+	c.streams[st.seq] = st
+	c.mu.Unlock()
+
+	req := &protocol.Request{
+		Kind:          protocol.KindStreamOpen,
+		ServiceMethod: serviceMethod,
+		Seq:           st.seq,
+		Metadata:      outgoingMetadata(ctx),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Deadline = deadline.UnixNano()
+	}
+	if err := c.write(req, args); err != nil {
+		c.mu.Lock()
+		delete(c.streams, st.seq)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.abortStream(st, ctx.Err())
+			case <-st.done:
+			}
+		}()
+	}
+	return st, nil
+}
+
+func (st *stream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *stream) SendMsg(msg any) error {
+	st.mu.Lock()
+	closed := st.sendClosed
+	st.mu.Unlock()
+	if closed {
+		return errSendClosed
+	}
+	if err := st.send.Acquire(st.ctx); err != nil {
+		return err
+	}
+	return st.client.write(&protocol.Request{
+		Kind:          protocol.KindStreamMsg,
+		ServiceMethod: st.method,
+		Seq:           st.seq,
+	}, msg)
+}
+
+func (st *stream) CloseSend() error {
+	st.mu.Lock()
+	if st.sendClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sendClosed = true
+	st.mu.Unlock()
+	return st.client.write(&protocol.Request{
+		Kind:          protocol.KindStreamEnd,
+		ServiceMethod: st.method,
+		Seq:           st.seq,
+	}, invalidBody)
+}
+
+func (st *stream) RecvMsg() (any, error) {
+	return st.recv.Pop(st.ctx)
+}
+
+// abortStream ends the stream with err on our side and tells the server
+// to stop the handler.
+func (c *Client) abortStream(st *stream, err error) {
+	c.mu.Lock()
+	if c.streams[st.seq] != st {
+		c.mu.Unlock()
+		return // already over
+	}
+	delete(c.streams, st.seq)
+	c.mu.Unlock()
+
+	c.sendCancel(st.method, st.seq)
+	st.finish(err, err)
+}
+
+func (c *Client) stream(seq uint64) *stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[seq]
+}
+
+func (c *Client) readStreamMsg(resp *protocol.Response) error {
+	st := c.stream(resp.Seq)
+	if st == nil {
+		return c.codec.ReadResponseBody(nil)
+	}
+	msg := st.newRecv()
+	if err := c.codec.ReadResponseBody(msg); err != nil {
+		c.abortStream(st, fmt.Errorf("failed to decode stream message: %w", err))
+		return nil // codecs read whole messages, so the connection is still in sync
+	}
+	if err := st.recv.Push(msg); err != nil {
+		c.abortStream(st, err)
+	}
+	return nil
+}
+
+func (c *Client) readStreamEnd(resp *protocol.Response) error {
+	if err := c.codec.ReadResponseBody(nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	st := c.streams[resp.Seq]
+	delete(c.streams, resp.Seq)
+	c.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+
+	var recvErr error = io.EOF
+	if err := resp.Err(); err != nil {
+		recvErr = err
+	}
+	st.finish(io.EOF, recvErr)
+	return nil
+}
+
+func (c *Client) readWindowUpdate(resp *protocol.Response) error {
+	if err := c.codec.ReadResponseBody(nil); err != nil {
+		return err
+	}
+	if st := c.stream(resp.Seq); st != nil {
+		st.send.Grant(int(resp.Window))
+	}
+	return nil
+}
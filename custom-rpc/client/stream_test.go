@@ -0,0 +1,214 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+type streamer struct {
+	sent    *atomic.Int64 // messages Count has handed to Send so far
+	stopped chan error
+}
+
+// Count sends 0..n-1.
+func (s streamer) Count(ctx context.Context, n *int, stream *server.Stream[struct{}, int]) error {
+	for i := range *n {
+		if err := stream.Send(&i); err != nil {
+			return err
+		}
+		s.sent.Add(1)
+	}
+	return nil
+}
+
+// Sum replies with the total once the client is done sending.
+func (streamer) Sum(ctx context.Context, stream *server.Stream[int, int]) error {
+	total := 0
+	for {
+		n, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.Send(&total)
+		}
+		if err != nil {
+			return err
+		}
+		total += *n
+	}
+}
+
+func (streamer) Echo(ctx context.Context, stream *server.Stream[string, string]) error {
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply := *msg + "!"
+		if err := stream.Send(&reply); err != nil {
+			return err
+		}
+	}
+}
+
+// Fail sends n messages and then fails the stream.
+func (streamer) Fail(ctx context.Context, n *int, stream *server.Stream[struct{}, int]) error {
+	for i := range *n {
+		if err := stream.Send(&i); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("failed after %d", *n)
+}
+
+func (s streamer) Block(ctx context.Context, stream *server.Stream[struct{}, struct{}]) error {
+	<-ctx.Done()
+	s.stopped <- ctx.Err()
+	return ctx.Err()
+}
+
+func startStreamer(t *testing.T) (*client.Client, streamer) {
+	t.Helper()
+	svc := streamer{sent: new(atomic.Int64), stopped: make(chan error, 1)}
+	s := server.NewServer()
+	if err := s.Register("Streamer", svc); err != nil {
+		t.Fatal(err)
+	}
+	return dial(t, serve(t, s)), svc
+}
+
+func TestServerStream(t *testing.T) {
+	c, svc := startStreamer(t)
+
+	const n = 3 * protocol.InitialWindow
+	stream, err := client.OpenStream[struct{}, int](context.Background(), c, "Streamer.Count", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler can only get a window's worth ahead of us
+	time.Sleep(50 * time.Millisecond)
+	if sent := svc.sent.Load(); sent != protocol.InitialWindow {
+		t.Fatalf("handler sent %d messages before any were read, want %d", sent, protocol.InitialWindow)
+	}
+
+	for i := range n {
+		got, err := stream.Recv()
+		if err != nil || *got != i {
+			t.Fatalf("Recv %d = %v, %v", i, got, err)
+		}
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after the last message = %v, want io.EOF", err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	c, _ := startStreamer(t)
+
+	stream, err := client.OpenStream[int, int](context.Background(), c, "Streamer.Sum", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for i := range 2 * protocol.InitialWindow {
+		if err := stream.Send(&i); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+		want += i
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := stream.Recv(); err != nil || *got != want {
+		t.Fatalf("Recv = %v, %v; want %d", got, err, want)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after the sum = %v, want io.EOF", err)
+	}
+}
+
+func TestBidirectionalStream(t *testing.T) {
+	c, _ := startStreamer(t)
+
+	stream, err := client.OpenStream[string, string](context.Background(), c, "Streamer.Echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := stream.Send(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := stream.Recv(); err != nil || *got != msg+"!" {
+			t.Fatalf("Recv = %v, %v; want %q", got, err, msg+"!")
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after CloseSend = %v, want io.EOF", err)
+	}
+	if msg := "late"; stream.Send(&msg) == nil {
+		t.Fatal("Send after CloseSend succeeded")
+	}
+}
+
+func TestStreamHandlerError(t *testing.T) {
+	c, _ := startStreamer(t)
+
+	stream, err := client.OpenStream[struct{}, int](context.Background(), c, "Streamer.Fail", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if got, err := stream.Recv(); err != nil || *got != i {
+			t.Fatalf("Recv %d = %v, %v", i, got, err)
+		}
+	}
+	var pe *protocol.Error
+	if _, err := stream.Recv(); !errors.As(err, &pe) || pe.Message != "failed after 2" {
+		t.Fatalf("Recv after the last message = %v, want a *protocol.Error %q", err, "failed after 2")
+	}
+
+	// streaming a unary method fails the same way
+	stream, err = client.OpenStream[struct{}, int](context.Background(), c, "Streamer.Nope", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, protocol.ErrUnimplemented) {
+		t.Fatalf("Recv on an unknown method = %v, want ErrUnimplemented", err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	c, svc := startStreamer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.OpenStream[struct{}, struct{}](ctx, c, "Streamer.Block", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := stream.Recv(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Recv = %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-svc.stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("handler stopped with %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceling the stream did not stop the handler")
+	}
+}
@@ -0,0 +1,307 @@
+// Command rpcgen generates a typed client and a server adapter from a Go
+// interface whose methods all have the form
+//
+//	Method(ctx context.Context, args *Args) (*Reply, error)
+//
+// (Args and Reply may also be non-pointer types). Put a directive next to
+// the interface and run go generate:
+//
+//	//go:generate go run github.com/shahin-bayat/custom-rpc/cmd/rpcgen -type Arith
+//
+// For an interface Arith this writes arith_rpc.go with an ArithClient that
+// wraps client.Client.Call, and a RegisterArith function that registers an
+// implementation of Arith with a server.Server.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type config struct {
+	typeName string
+	service  string
+	input    string
+	output   string
+}
+
+// Method is one interface method in the shape rpcgen supports.
+type Method struct {
+	Name         string
+	ArgsType     string // as written in the interface
+	ArgsPointer  bool
+	ReplyType    string // as written in the interface
+	ReplyPointer bool
+	ReplyElem    string // ReplyType without the leading *, if any
+}
+
+// File is everything the template needs.
+type File struct {
+	Package string
+	Type    string
+	Service string
+	Imports []string // import lines beyond the fixed ones, such as `"time"` or `foo "example.com/bar"`
+	Methods []Method
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.typeName, "type", "", "name of the interface to generate code for (required)")
+	flag.StringVar(&cfg.service, "service", "", "service name to register under; defaults to -type")
+	flag.StringVar(&cfg.input, "file", os.Getenv("GOFILE"), "Go file declaring the interface; defaults to $GOFILE")
+	flag.StringVar(&cfg.output, "output", "", "output file; defaults to <type>_rpc.go next to the input")
+	flag.Parse()
+
+	if cfg.typeName == "" || cfg.input == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if cfg.service == "" {
+		cfg.service = cfg.typeName
+	}
+	if cfg.output == "" {
+		cfg.output = filepath.Join(filepath.Dir(cfg.input), strings.ToLower(cfg.typeName)+"_rpc.go")
+	}
+
+	if err := run(&cfg); err != nil {
+		slog.Error("rpcgen failed", "type", cfg.typeName, "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, cfg.input, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", cfg.input, err)
+	}
+	iface, err := findInterface(file, cfg.typeName)
+	if err != nil {
+		return err
+	}
+
+	out := File{
+		Package: file.Name.Name,
+		Type:    cfg.typeName,
+		Service: cfg.service,
+	}
+	var errs []error
+	var exprs []ast.Expr
+	for _, field := range iface.Methods.List {
+		if len(field.Names) == 0 {
+			errs = append(errs, fmt.Errorf("%s: embedded interfaces are not supported", types.ExprString(field.Type)))
+			continue
+		}
+		m, err := parseMethod(field)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", cfg.typeName, field.Names[0].Name, err))
+			continue
+		}
+		out.Methods = append(out.Methods, m)
+		fn := field.Type.(*ast.FuncType)
+		exprs = append(exprs, fn.Params.List[1].Type, fn.Results.List[0].Type)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if len(out.Methods) == 0 {
+		return fmt.Errorf("interface %s has no methods", cfg.typeName)
+	}
+	if out.Imports, err = usedImports(file, exprs); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, out); err != nil {
+		return fmt.Errorf("failed to render code: %w", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format generated code: %w", err)
+	}
+	if err := os.WriteFile(cfg.output, src, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", cfg.output, err)
+	}
+	slog.Info("generated rpc code", "type", cfg.typeName, "output", cfg.output)
+	return nil
+}
+
+func findInterface(file *ast.File, name string) (*ast.InterfaceType, error) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Name.Name != name {
+				continue
+			}
+			iface, ok := ts.Type.(*ast.InterfaceType)
+			if !ok {
+				return nil, fmt.Errorf("type %s is not an interface", name)
+			}
+			return iface, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+func parseMethod(field *ast.Field) (Method, error) {
+	const want = "want (ctx context.Context, args Args) (Reply, error)"
+
+	fn, ok := field.Type.(*ast.FuncType)
+	if !ok {
+		return Method{}, fmt.Errorf("not a method")
+	}
+	params := flatten(fn.Params)
+	results := flatten(fn.Results)
+	if len(params) != 2 || len(results) != 2 {
+		return Method{}, fmt.Errorf("has %d arguments and %d results; %s", len(params), len(results), want)
+	}
+	if types.ExprString(params[0]) != "context.Context" {
+		return Method{}, fmt.Errorf("first argument is %s; %s", types.ExprString(params[0]), want)
+	}
+	if types.ExprString(results[1]) != "error" {
+		return Method{}, fmt.Errorf("last result is %s; %s", types.ExprString(results[1]), want)
+	}
+
+	m := Method{
+		Name:      field.Names[0].Name,
+		ArgsType:  types.ExprString(params[1]),
+		ReplyType: types.ExprString(results[0]),
+	}
+	_, m.ArgsPointer = params[1].(*ast.StarExpr)
+	m.ReplyElem = m.ReplyType
+	if star, ok := results[0].(*ast.StarExpr); ok {
+		m.ReplyPointer = true
+		m.ReplyElem = types.ExprString(star.X)
+	}
+	return m, nil
+}
+
+// flatten expands grouped fields such as (a, b int) into one type per name.
+func flatten(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var exprs []ast.Expr
+	for _, f := range fields.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			exprs = append(exprs, f.Type)
+		}
+	}
+	return exprs
+}
+
+// usedImports returns the imports of file that exprs refer to, so types
+// such as time.Duration keep compiling in the generated file.
+func usedImports(file *ast.File, exprs []ast.Expr) ([]string, error) {
+	used := make(map[string]bool)
+	for _, expr := range exprs {
+		ast.Inspect(expr, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					used[x.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	var imports []string
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad import %s: %w", spec.Path.Value, err)
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if !used[name] || name == "context" {
+			continue // context is always imported
+		}
+		if spec.Name != nil {
+			imports = append(imports, spec.Name.Name+" "+spec.Path.Value)
+		} else {
+			imports = append(imports, spec.Path.Value)
+		}
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+var fileTemplate = template.Must(template.New("rpc").Funcs(template.FuncMap{
+	"lowerFirst": func(s string) string {
+		return strings.ToLower(s[:1]) + s[1:]
+	},
+}).Parse(`// Code generated by rpcgen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{range .Imports}}	{{.}}
+{{end}}
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+// {{.Type}}Client calls a remote {{.Type}} registered as "{{.Service}}".
+type {{.Type}}Client struct {
+	c *client.Client
+}
+
+func New{{.Type}}Client(c *client.Client) *{{.Type}}Client {
+	return &{{.Type}}Client{c: c}
+}
+{{range .Methods}}
+func (x *{{$.Type}}Client) {{.Name}}(ctx context.Context, args {{.ArgsType}}) ({{.ReplyType}}, error) {
+	reply := new({{.ReplyElem}})
+	if err := x.c.Call(ctx, "{{$.Service}}.{{.Name}}", args, reply); err != nil {
+		{{if .ReplyPointer}}return nil, err{{else}}return *reply, err{{end}}
+	}
+	return {{if not .ReplyPointer}}*{{end}}reply, nil
+}
+{{end}}
+// Register{{.Type}} registers impl with s as "{{.Service}}".
+func Register{{.Type}}(s *server.Server, impl {{.Type}}, opts ...server.RegisterOption) error {
+	return s.Register("{{.Service}}", &{{lowerFirst .Type}}Service{impl: impl}, opts...)
+}
+
+// {{lowerFirst .Type}}Service adapts {{.Type}} to the method shape server.Server expects.
+type {{lowerFirst .Type}}Service struct {
+	impl {{.Type}}
+}
+{{range .Methods}}
+func (s *{{lowerFirst $.Type}}Service) {{.Name}}(ctx context.Context, args *{{if .ArgsPointer}}{{slice .ArgsType 1}}{{else}}{{.ArgsType}}{{end}}, reply *{{.ReplyElem}}) error {
+	r, err := s.impl.{{.Name}}(ctx, {{if not .ArgsPointer}}*{{end}}args)
+	if err != nil {
+		return err
+	}
+	{{if .ReplyPointer}}if r != nil {
+		*reply = *r
+	}{{else}}*reply = r{{end}}
+	return nil
+}
+{{end}}`))
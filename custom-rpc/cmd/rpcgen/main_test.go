@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGolden(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "arith_rpc.go")
+	cfg := &config{typeName: "Arith", service: "Calc", input: "testdata/arith.go", output: out}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "arith_rpc.go.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("generated code differs from %s; rerun with -update if the change is intended\n%s", golden, got)
+	}
+}
+
+func TestUnsupportedInterfaces(t *testing.T) {
+	tests := []struct {
+		typeName string
+		err      string
+	}{
+		{"NoContext", "has 1 arguments and 2 results"},
+		{"NoError", "last result is bool"},
+		{"TooManyArgs", "has 3 arguments and 2 results"},
+		{"Embeds", "embedded interfaces are not supported"},
+		{"Empty", "has no methods"},
+		{"NotAnInterface", "is not an interface"},
+		{"Missing", "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeName, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.go")
+			err := run(&config{typeName: tt.typeName, service: tt.typeName, input: "testdata/bad.go", output: out})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("run error = %v, want it to contain %q", err, tt.err)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Fatal("wrote output for an unsupported interface")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package arith
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	units "example.com/units"
+)
+
+type Arith interface {
+	Add(ctx context.Context, args *Args) (*Reply, error)
+	Sum(ctx context.Context, xs []int) (int, error)
+	Sleep(ctx context.Context, d time.Duration) (*Reply, error)
+	Convert(ctx context.Context, m units.Meters) (units.Feet, error)
+}
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+// Handler is here to check that unused imports stay out.
+type Handler = http.Handler
@@ -0,0 +1,23 @@
+package bad
+
+import "context"
+
+type NoContext interface {
+	Get(args *string) (*string, error)
+}
+
+type NoError interface {
+	Get(ctx context.Context, args *string) (*string, bool)
+}
+
+type TooManyArgs interface {
+	Get(ctx context.Context, a, b *string) (*string, error)
+}
+
+type Embeds interface {
+	NoError
+}
+
+type Empty interface{}
+
+type NotAnInterface struct{}
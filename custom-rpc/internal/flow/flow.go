@@ -0,0 +1,169 @@
+// Package flow implements the per-stream, message-counted flow control
+// shared by the client and the server. Each side of a stream may have at
+// most protocol.InitialWindow messages in flight towards its peer; the
+// peer hands credit back with window updates as its application consumes
+// messages.
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrWindowExceeded = errors.New("flow control window exceeded")
+
+// SendWindow counts how many more messages may be sent.
+type SendWindow struct {
+	mu     sync.Mutex
+	credit int
+	err    error
+	signal chan struct{} // closed and replaced whenever credit or err changes
+}
+
+func NewSendWindow(credit int) *SendWindow {
+	return &SendWindow{
+		credit: credit,
+		signal: make(chan struct{}),
+	}
+}
+
+// Acquire takes one unit of credit, waiting for the peer to grant more if
+// there is none left.
+func (w *SendWindow) Acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if w.err != nil {
+			w.mu.Unlock()
+			return w.err
+		}
+		if w.credit > 0 {
+			w.credit--
+			w.mu.Unlock()
+			return nil
+		}
+		signal := w.signal
+		w.mu.Unlock()
+
+		select {
+		case <-signal:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Grant adds credit sent by the peer.
+func (w *SendWindow) Grant(n int) {
+	w.mu.Lock()
+	w.credit += n
+	w.notify()
+	w.mu.Unlock()
+}
+
+// Close makes every current and future Acquire fail with err.
+func (w *SendWindow) Close(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.notify()
+	w.mu.Unlock()
+}
+
+// notify wakes every waiting Acquire. It must be called with w.mu held.
+func (w *SendWindow) notify() {
+	close(w.signal)
+	w.signal = make(chan struct{})
+}
+
+// RecvQueue buffers messages that arrived ahead of the application. It
+// never blocks the connection's read loop: a peer that sends beyond its
+// window gets ErrWindowExceeded instead.
+type RecvQueue struct {
+	msgs   chan any
+	window int
+	grant  func(n int) // sends credit back to the peer
+
+	mu       sync.Mutex
+	consumed int // messages popped since the last grant
+	err      error
+	done     chan struct{} // closed with err set once no more messages will arrive
+}
+
+func NewRecvQueue(window int, grant func(n int)) *RecvQueue {
+	return &RecvQueue{
+		msgs:   make(chan any, window),
+		window: window,
+		grant:  grant,
+		done:   make(chan struct{}),
+	}
+}
+
+// Push queues a message from the peer.
+func (q *RecvQueue) Push(msg any) error {
+	select {
+	case <-q.done:
+		return q.err
+	default:
+	}
+	select {
+	case q.msgs <- msg:
+		return nil
+	default:
+		return ErrWindowExceeded
+	}
+}
+
+// Pop returns the next message. Once the queue is closed and drained it
+// returns the error it was closed with.
+func (q *RecvQueue) Pop(ctx context.Context) (any, error) {
+	select {
+	case msg := <-q.msgs:
+		return q.consume(msg), nil
+	default:
+	}
+	select {
+	case msg := <-q.msgs:
+		return q.consume(msg), nil
+	case <-q.done:
+		// messages queued before the close still count
+		select {
+		case msg := <-q.msgs:
+			return q.consume(msg), nil
+		default:
+			return nil, q.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// consume hands credit back in batches of half a window, so the peer
+// rarely stalls and window updates stay infrequent.
+func (q *RecvQueue) consume(msg any) any {
+	q.mu.Lock()
+	q.consumed++
+	n := 0
+	if q.consumed >= q.window/2 && q.err == nil {
+		n = q.consumed
+		q.consumed = 0
+	}
+	q.mu.Unlock()
+	if n > 0 {
+		q.grant(n)
+	}
+	return msg
+}
+
+// Close marks the end of the incoming messages; err is what Pop returns
+// after the remaining ones, typically io.EOF. Only the first call counts.
+func (q *RecvQueue) Close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return
+	}
+	q.err = err
+	close(q.done)
+}
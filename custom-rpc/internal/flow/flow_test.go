@@ -0,0 +1,118 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSendWindow(t *testing.T) {
+	w := NewSendWindow(2)
+	ctx := context.Background()
+	for i := range 2 {
+		if err := w.Acquire(ctx); err != nil {
+			t.Fatalf("Acquire %d: %v", i, err)
+		}
+	}
+
+	// out of credit: Acquire waits for a grant
+	acquired := make(chan error, 1)
+	go func() { acquired <- w.Acquire(ctx) }()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Acquire without credit returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	w.Grant(1)
+	if err := <-acquired; err != nil {
+		t.Fatalf("Acquire after Grant: %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := w.Acquire(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire with an expiring context = %v, want context.DeadlineExceeded", err)
+	}
+
+	closed := errors.New("closed")
+	w.Close(closed)
+	w.Close(io.EOF) // only the first error counts
+	w.Grant(5)
+	if err := w.Acquire(ctx); err != closed {
+		t.Fatalf("Acquire after Close = %v, want %v", err, closed)
+	}
+}
+
+func TestRecvQueue(t *testing.T) {
+	var granted []int
+	q := NewRecvQueue(4, func(n int) { granted = append(granted, n) })
+	ctx := context.Background()
+
+	for i := range 4 {
+		if err := q.Push(i); err != nil {
+			t.Fatalf("Push %d: %v", i, err)
+		}
+	}
+	if err := q.Push(4); !errors.Is(err, ErrWindowExceeded) {
+		t.Fatalf("Push beyond the window = %v, want ErrWindowExceeded", err)
+	}
+
+	for i := range 3 {
+		msg, err := q.Pop(ctx)
+		if err != nil || msg != i {
+			t.Fatalf("Pop = %v, %v; want %d", msg, err, i)
+		}
+	}
+	// credit goes back in batches of half a window
+	if len(granted) != 1 || granted[0] != 2 {
+		t.Fatalf("granted = %v, want [2]", granted)
+	}
+
+	q.Close(io.EOF)
+	q.Close(errors.New("ignored"))
+	if err := q.Push(9); !errors.Is(err, io.EOF) {
+		t.Fatalf("Push after Close = %v, want io.EOF", err)
+	}
+	// messages queued before the close are still delivered
+	if msg, err := q.Pop(ctx); err != nil || msg != 3 {
+		t.Fatalf("Pop after Close = %v, %v; want 3", msg, err)
+	}
+	if _, err := q.Pop(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("Pop of a drained queue = %v, want io.EOF", err)
+	}
+	if len(granted) != 1 {
+		t.Fatalf("granted credit after Close: %v", granted)
+	}
+}
+
+func TestRecvQueuePopContext(t *testing.T) {
+	q := NewRecvQueue(4, func(int) {})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Pop = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestSendWindowWakesEveryWaiter(t *testing.T) {
+	w := NewSendWindow(0)
+	const waiters = 4
+	acquired := make(chan error, waiters)
+	for range waiters {
+		go func() { acquired <- w.Acquire(context.Background()) }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	w.Grant(waiters)
+	for range waiters {
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("a waiter was not woken by Grant")
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package jsonrpc serves the unary methods of a server.Server as JSON-RPC
+// 2.0 over HTTP, so they can be called from a browser or with curl:
+//
+//	curl -d '{"jsonrpc":"2.0","id":1,"method":"Arith.Add","params":{"A":1,"B":2}}' localhost:8080/rpc
+//
+// Batches and notifications are supported; streaming methods are not.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+const version = "2.0"
+
+// maxBodySize bounds a request body, batches included.
+const maxBodySize = 4 << 20
+
+// Error codes defined by the JSON-RPC 2.0 specification. Other failures
+// are reported as CodeServerError minus their protocol.Code, so a
+// protocol.CodeNotFound becomes -32005.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorData `json:"data,omitempty"`
+}
+
+// ErrorData carries the protocol.Error behind a JSON-RPC error, so clients
+// can tell failures apart the same way Go clients do.
+type ErrorData struct {
+	Code    string `json:"code"` // protocol.Code in text form, such as "not found"
+	Details []byte `json:"details,omitempty"`
+}
+
+// Handler is an http.Handler that calls the methods registered with a
+// server.Server. Calls go through the server's interceptors.
+type Handler struct {
+	server *server.Server
+}
+
+func NewHandler(s *server.Server) *Handler {
+	return &Handler{server: s}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.Warn("failed to read jsonrpc request", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		writeJSON(w, errorResponse(nil, &Error{Code: CodeParseError, Message: "invalid JSON"}))
+		return
+	}
+	if body[0] == '[' {
+		h.serveBatch(r.Context(), w, body)
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: err.Error()}))
+		return
+	}
+	resp := h.handle(r.Context(), &req)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent) // a notification
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// serveBatch runs the calls of a batch concurrently and answers with the
+// responses of those that are not notifications, in request order.
+func (h *Handler) serveBatch(ctx context.Context, w http.ResponseWriter, body []byte) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil || len(raw) == 0 {
+		writeJSON(w, errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "empty batch"}))
+		return
+	}
+
+	responses := make([]*response, len(raw))
+	var wg sync.WaitGroup
+	for i, msg := range raw {
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			responses[i] = errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: err.Error()})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = h.handle(ctx, &req)
+		}()
+	}
+	wg.Wait()
+
+	var out []*response
+	for _, resp := range responses {
+		if resp != nil {
+			out = append(out, resp)
+		}
+	}
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusNoContent) // only notifications
+		return
+	}
+	writeJSON(w, out)
+}
+
+// handle runs one call. It returns nil for notifications, which get no
+// response even when they fail.
+func (h *Handler) handle(ctx context.Context, req *request) *response {
+	if err := validate(req); err != nil {
+		return errorResponse(req.ID, err)
+	}
+
+	var paramsErr error
+	reply, err := h.server.Invoke(ctx, req.Method, func(args any) error {
+		paramsErr = decodeParams(req.Params, args)
+		return paramsErr
+	})
+	if req.ID == nil {
+		if err != nil {
+			slog.Warn("jsonrpc notification failed", "method", req.Method, "error", err)
+		}
+		return nil
+	}
+	if err != nil {
+		rpcErr := toError(err)
+		if paramsErr != nil {
+			rpcErr.Code = CodeInvalidParams
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+	return &response{Version: version, Result: reply, ID: req.ID}
+}
+
+func validate(req *request) *Error {
+	if req.Version != version {
+		return &Error{Code: CodeInvalidRequest, Message: `jsonrpc must be "2.0"`}
+	}
+	if req.Method == "" {
+		return &Error{Code: CodeInvalidRequest, Message: "method is required"}
+	}
+	if req.ID != nil {
+		switch req.ID[0] {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		default:
+			req.ID = nil // answer with a null id, as the spec asks
+			return &Error{Code: CodeInvalidRequest, Message: "id must be a string, number or null"}
+		}
+	}
+	return nil
+}
+
+// decodeParams fills in args from params, which is either the Args value
+// itself or a one-element array holding it. Absent params leave args zero.
+func decodeParams(params json.RawMessage, args any) error {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if params[0] == '[' {
+		switch reflect.TypeOf(args).Elem().Kind() {
+		case reflect.Slice, reflect.Array:
+			// a positional array is the args themselves
+		default:
+			var positional []json.RawMessage
+			if err := json.Unmarshal(params, &positional); err != nil {
+				return err
+			}
+			if len(positional) != 1 {
+				return fmt.Errorf("want 1 positional param, got %d", len(positional))
+			}
+			params = positional[0]
+		}
+	}
+	return json.Unmarshal(params, args)
+}
+
+// toError maps a call's error to a JSON-RPC error object.
+func toError(err error) *Error {
+	code := protocol.CodeOf(err)
+	rpcErr := &Error{Message: err.Error(), Data: &ErrorData{Code: code.String()}}
+	var e *protocol.Error
+	if errors.As(err, &e) {
+		rpcErr.Message = e.Message
+		rpcErr.Data.Details = e.Details
+	}
+	switch code {
+	case protocol.CodeUnimplemented:
+		rpcErr.Code = CodeMethodNotFound
+	case protocol.CodeInvalidArgument:
+		rpcErr.Code = CodeInvalidParams
+	case protocol.CodeInternal:
+		rpcErr.Code = CodeInternalError
+	default:
+		rpcErr.Code = CodeServerError - int(code)
+	}
+	return rpcErr
+}
+
+func errorResponse(id json.RawMessage, err *Error) *response {
+	return &response{Version: version, Error: err, ID: id}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write jsonrpc response", "error", err)
+	}
+}
@@ -0,0 +1,239 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shahin-bayat/custom-rpc/jsonrpc"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+type Args struct{ A, B int }
+
+type arith struct {
+	notified *atomic.Int64
+}
+
+func (arith) Add(ctx context.Context, args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (arith) Sum(ctx context.Context, xs *[]int, reply *int) error {
+	for _, x := range *xs {
+		*reply += x
+	}
+	return nil
+}
+
+func (a arith) Notify(ctx context.Context, args *Args, reply *struct{}) error {
+	a.notified.Add(1)
+	return nil
+}
+
+func (arith) Find(ctx context.Context, args *Args, reply *int) error {
+	return &protocol.Error{Code: protocol.CodeNotFound, Message: "no such entry", Details: []byte("x")}
+}
+
+func (arith) Boom(ctx context.Context, args *Args, reply *int) error { panic("boom") }
+
+func (arith) Watch(ctx context.Context, stream *server.Stream[int, int]) error { return nil }
+
+func newServer(t *testing.T, opts ...server.Option) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	notified := new(atomic.Int64)
+	s := server.NewServer(opts...)
+	if err := s.Register("Arith", arith{notified: notified}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(jsonrpc.NewHandler(s))
+	t.Cleanup(ts.Close)
+	return ts, notified
+}
+
+func post(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// sameJSON reports whether a and b hold the same JSON value.
+func sameJSON(a, b string) bool {
+	var x, y any
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestHandler(t *testing.T) {
+	ts, _ := newServer(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string // the JSON response; empty for none
+	}{
+		{
+			"named params",
+			`{"jsonrpc":"2.0","id":1,"method":"Arith.Add","params":{"A":1,"B":2}}`,
+			200, `{"jsonrpc":"2.0","result":3,"id":1}`,
+		},
+		{
+			"positional params",
+			`{"jsonrpc":"2.0","id":"x","method":"Arith.Add","params":[{"A":1,"B":5}]}`,
+			200, `{"jsonrpc":"2.0","result":6,"id":"x"}`,
+		},
+		{
+			"array args",
+			`{"jsonrpc":"2.0","id":2,"method":"Arith.Sum","params":[1,2,3]}`,
+			200, `{"jsonrpc":"2.0","result":6,"id":2}`,
+		},
+		{
+			"unknown method",
+			`{"jsonrpc":"2.0","id":3,"method":"Arith.Nope"}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"unknown method \"Arith.Nope\"","data":{"code":"unimplemented"}},"id":3}`,
+		},
+		{
+			"coded error",
+			`{"jsonrpc":"2.0","id":5,"method":"Arith.Find"}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32005,"message":"no such entry","data":{"code":"not found","details":"eA=="}},"id":5}`,
+		},
+		{
+			"panic",
+			`{"jsonrpc":"2.0","id":6,"method":"Arith.Boom"}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"panic in Arith.Boom: boom","data":{"code":"internal"}},"id":6}`,
+		},
+		{
+			"notification",
+			`{"jsonrpc":"2.0","method":"Arith.Add"}`,
+			204, "",
+		},
+		{
+			"failed notification",
+			`{"jsonrpc":"2.0","method":"Arith.Boom"}`,
+			204, "",
+		},
+		{
+			"wrong version",
+			`{"jsonrpc":"1.0","id":1,"method":"Arith.Add"}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc must be \"2.0\""},"id":1}`,
+		},
+		{
+			"object id",
+			`{"jsonrpc":"2.0","id":{},"method":"Arith.Add"}`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"id must be a string, number or null"},"id":null}`,
+		},
+		{
+			"invalid JSON",
+			`{bad`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid JSON"},"id":null}`,
+		},
+		{
+			"empty batch",
+			`[]`,
+			200, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		},
+		{
+			"batch",
+			`[1,{"jsonrpc":"2.0","method":"Arith.Add"},{"jsonrpc":"2.0","id":7,"method":"Arith.Add","params":{"A":2}},{"jsonrpc":"2.0","id":8,"method":"Arith.Nope"}]`,
+			200, `[
+				{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type jsonrpc.request"},"id":null},
+				{"jsonrpc":"2.0","result":2,"id":7},
+				{"jsonrpc":"2.0","error":{"code":-32601,"message":"unknown method \"Arith.Nope\"","data":{"code":"unimplemented"}},"id":8}
+			]`,
+		},
+		{
+			"batch of notifications",
+			`[{"jsonrpc":"2.0","method":"Arith.Add"},{"jsonrpc":"2.0","method":"Arith.Sum"}]`,
+			204, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(t, ts.URL, tt.body)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", status, tt.status, body)
+			}
+			if tt.want == "" {
+				if body != "" {
+					t.Fatalf("body = %s, want none", body)
+				}
+				return
+			}
+			if !sameJSON(body, tt.want) {
+				t.Fatalf("body =\n%s\nwant\n%s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerInvalidParams(t *testing.T) {
+	ts, _ := newServer(t)
+
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"Arith.Add","params":"bad"}`,
+		`{"jsonrpc":"2.0","id":1,"method":"Arith.Add","params":[{"A":1},{"A":2}]}`,
+		// streams cannot be called over JSON-RPC
+		`{"jsonrpc":"2.0","id":1,"method":"Arith.Watch"}`,
+	} {
+		_, resp := post(t, ts.URL, body)
+		var got struct {
+			Error struct{ Code int }
+		}
+		if err := json.Unmarshal([]byte(resp), &got); err != nil || got.Error.Code != jsonrpc.CodeInvalidParams {
+			t.Errorf("%s: response %s, want error code %d", body, resp, jsonrpc.CodeInvalidParams)
+		}
+	}
+}
+
+func TestHandlerNotificationRuns(t *testing.T) {
+	ts, notified := newServer(t)
+	post(t, ts.URL, `{"jsonrpc":"2.0","method":"Arith.Notify"}`)
+	post(t, ts.URL, `[{"jsonrpc":"2.0","method":"Arith.Notify"},{"jsonrpc":"2.0","method":"Arith.Notify"}]`)
+	if n := notified.Load(); n != 3 {
+		t.Fatalf("Notify ran %d times, want 3", n)
+	}
+}
+
+func TestHandlerRunsInterceptors(t *testing.T) {
+	var seen atomic.Value
+	record := func(ctx context.Context, method string, args, reply any, next server.UnaryHandler) error {
+		seen.Store(method)
+		return next(ctx, args, reply)
+	}
+	ts, _ := newServer(t, server.WithUnaryInterceptors(record))
+	post(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"Arith.Add"}`)
+	if got := seen.Load(); got != "Arith.Add" {
+		t.Fatalf("interceptor saw %v, want Arith.Add", got)
+	}
+}
+
+func TestHandlerMethodNotAllowed(t *testing.T) {
+	ts, _ := newServer(t)
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Fatalf("GET = %d with Allow %q, want 405 with Allow POST", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxFrameSize bounds a single header or body so a corrupt length prefix
+// cannot make us allocate unbounded memory.
+const maxFrameSize = 64 << 20
+
+type binaryCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewBinaryCodec returns a codec that writes every header and body as a
+// frame of a 4-byte big-endian length followed by the compact encoding of
+// Marshal. It carries no type information, so it is the cheapest codec on
+// the wire.
+func NewBinaryCodec(conn io.ReadWriteCloser) Codec {
+	return &binaryCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+func (c *binaryCodec) ReadRequestHeader(r *Request) error {
+	return c.readFrame(r)
+}
+
+func (c *binaryCodec) ReadRequestBody(body any) error {
+	return c.readFrame(body)
+}
+
+func (c *binaryCodec) WriteRequest(r *Request, body any) error {
+	return c.write(r, body)
+}
+
+func (c *binaryCodec) ReadResponseHeader(r *Response) error {
+	return c.readFrame(r)
+}
+
+func (c *binaryCodec) ReadResponseBody(body any) error {
+	return c.readFrame(body)
+}
+
+func (c *binaryCodec) WriteResponse(r *Response, body any) error {
+	return c.write(r, body)
+}
+
+func (c *binaryCodec) readFrame(v any) error {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit of %d", n, maxFrameSize)
+	}
+	if v == nil {
+		_, err := c.r.Discard(int(n))
+		return err
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return err
+	}
+	return Unmarshal(frame, v)
+}
+
+func (c *binaryCodec) write(header, body any) error {
+	for _, v := range []any{header, body} {
+		frame, err := Marshal(v)
+		if err != nil {
+			return err
+		}
+		if len(frame) > maxFrameSize {
+			return fmt.Errorf("frame of %d bytes exceeds limit of %d", len(frame), maxFrameSize)
+		}
+		if err := binary.Write(c.w, binary.BigEndian, uint32(len(frame))); err != nil {
+			return err
+		}
+		if _, err := c.w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
+
+func (c *binaryCodec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Codec reads and writes the headers and bodies of calls on one
+// connection. Writes must be serialized by the caller; reads happen from a
+// single goroutine. Passing nil to a Read*Body method discards the body.
+type Codec interface {
+	ReadRequestHeader(*Request) error
+	ReadRequestBody(body any) error
+	WriteRequest(*Request, any) error
+
+	ReadResponseHeader(*Response) error
+	ReadResponseBody(body any) error
+	WriteResponse(*Response, any) error
+
+	Close() error
+}
+
+// NewCodecFunc builds a codec on top of an already negotiated connection.
+type NewCodecFunc func(conn io.ReadWriteCloser) Codec
+
+const DefaultCodec = "gob"
+
+var codecs = map[string]NewCodecFunc{
+	"gob":    NewGobCodec,
+	"json":   NewJSONCodec,
+	"binary": NewBinaryCodec,
+}
+
+// CodecNames lists the codecs that can be negotiated.
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The handshake is one line each way, before any codec traffic:
+//
+//	client: RPC <codec>\n
+//	server: OK\n  or  ERR <reason>\n
+const handshakePrefix = "RPC "
+
+// maxHandshakeLine bounds a handshake line, so a peer that never sends a
+// newline cannot make us buffer without end.
+const maxHandshakeLine = 256
+
+var errHandshakeTooLong = errors.New("handshake line too long")
+
+// readHandshakeLine reads one handshake line without its line ending.
+func readHandshakeLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for len(line) <= maxHandshakeLine {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, c)
+	}
+	return "", errHandshakeTooLong
+}
+
+// ClientHandshake asks the server to speak the named codec and returns it
+// once the server has agreed.
+func ClientHandshake(conn io.ReadWriteCloser, name string) (Codec, error) {
+	newCodec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %q", name)
+	}
+	if _, err := fmt.Fprintf(conn, "%s%s\n", handshakePrefix, name); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := readHandshakeLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake reply: %w", err)
+	}
+	if line != "OK" {
+		return nil, fmt.Errorf("handshake rejected: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	return newCodec(&bufferedConn{r: r, ReadWriteCloser: conn}), nil
+}
+
+// ServerHandshake reads the codec the client asks for and returns it, or
+// tells the client why it was refused. It does not time out by itself;
+// callers with a net.Conn should set a deadline around it.
+func ServerHandshake(conn io.ReadWriteCloser) (Codec, error) {
+	r := bufio.NewReader(conn)
+	line, err := readHandshakeLine(r)
+	if errors.Is(err, errHandshakeTooLong) {
+		fmt.Fprintf(conn, "ERR malformed handshake\n")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if !strings.HasPrefix(line, handshakePrefix) {
+		fmt.Fprintf(conn, "ERR malformed handshake\n")
+		return nil, fmt.Errorf("malformed handshake %q", line)
+	}
+	name := strings.TrimPrefix(line, handshakePrefix)
+	newCodec, ok := codecs[name]
+	if !ok {
+		fmt.Fprintf(conn, "ERR unsupported codec %s, want one of %s\n", name, strings.Join(CodecNames(), ", "))
+		return nil, fmt.Errorf("unsupported codec %q", name)
+	}
+	if _, err := io.WriteString(conn, "OK\n"); err != nil {
+		return nil, fmt.Errorf("failed to send handshake reply: %w", err)
+	}
+	return newCodec(&bufferedConn{r: r, ReadWriteCloser: conn}), nil
+}
+
+// bufferedConn keeps reading through the handshake's bufio.Reader so that
+// bytes it already buffered are not lost.
+type bufferedConn struct {
+	r *bufio.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
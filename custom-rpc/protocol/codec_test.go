@@ -0,0 +1,162 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeConn reads from in and collects what is written to it.
+type fakeConn struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (c *fakeConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *fakeConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *fakeConn) Close() error                { return nil }
+
+func TestServerHandshake(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		ok    bool
+		reply string // the start of what the server answers
+	}{
+		{"gob", "RPC gob\n", true, "OK\n"},
+		{"json with CRLF", "RPC json\r\n", true, "OK\n"},
+		{"binary", "RPC binary\n", true, "OK\n"},
+		{"unknown codec", "RPC xml\n", false, "ERR unsupported codec xml"},
+		{"no prefix", "HELLO gob\n", false, "ERR malformed handshake"},
+		{"lower-case prefix", "rpc gob\n", false, "ERR malformed handshake"},
+		{"empty line", "\n", false, "ERR malformed handshake"},
+		{"no newline", "RPC gob", false, ""},
+		{"nothing", "", false, ""},
+		{"line too long", "RPC " + strings.Repeat("g", maxHandshakeLine) + "\n", false, "ERR malformed handshake"},
+		{"no newline, over the limit", strings.Repeat("x", 10*maxHandshakeLine), false, "ERR malformed handshake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{in: strings.NewReader(tt.in)}
+			codec, err := ServerHandshake(conn)
+			if tt.ok != (err == nil) {
+				t.Fatalf("ServerHandshake error = %v, want ok: %v", err, tt.ok)
+			}
+			if tt.ok != (codec != nil) {
+				t.Fatalf("ServerHandshake codec = %v", codec)
+			}
+			got := conn.out.String()
+			if (tt.reply == "" && got != "") || !strings.HasPrefix(got, tt.reply) {
+				t.Fatalf("server replied %q, want %q", got, tt.reply)
+			}
+		})
+	}
+}
+
+func TestClientHandshake(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec string
+		reply string
+		ok    bool
+	}{
+		{"accepted", "gob", "OK\n", true},
+		{"accepted with CRLF", "json", "OK\r\n", true},
+		{"refused", "gob", "ERR unsupported codec gob\n", false},
+		{"no reply", "gob", "", false},
+		{"unknown codec", "xml", "OK\n", false},
+		{"endless reply", "gob", strings.Repeat("O", 10*maxHandshakeLine), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{in: strings.NewReader(tt.reply)}
+			_, err := ClientHandshake(conn, tt.codec)
+			if tt.ok != (err == nil) {
+				t.Fatalf("ClientHandshake error = %v, want ok: %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// TestHandshakeKeepsBufferedBytes checks that codec traffic the client
+// sent right behind its handshake line is not lost.
+func TestHandshakeKeepsBufferedBytes(t *testing.T) {
+	var in bytes.Buffer
+	in.WriteString("RPC binary\n")
+	frame, err := Marshal(Request{ServiceMethod: "S.M", Seq: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Write(binary.BigEndian.AppendUint32(nil, uint32(len(frame))))
+	in.Write(frame)
+
+	codec, err := ServerHandshake(&fakeConn{in: &in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req Request
+	if err := codec.ReadRequestHeader(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ServiceMethod != "S.M" || req.Seq != 1 {
+		t.Fatalf("header = %+v", req)
+	}
+}
+
+func TestBinaryCodecFrames(t *testing.T) {
+	prefix := func(n uint32) []byte { return binary.BigEndian.AppendUint32(nil, n) }
+	zero, err := Marshal(Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		in   []byte
+		ok   bool
+	}{
+		{"zero header", append(prefix(uint32(len(zero))), zero...), true},
+		{"empty frame", prefix(0), false},
+		{"over the frame limit", prefix(maxFrameSize + 1), false},
+		{"largest prefix", prefix(0xffffffff), false},
+		{"short prefix", []byte{0, 0}, false},
+		{"frame cut short", append(prefix(10), 1, 2, 3), false},
+		{"garbage in frame", append(prefix(2), 0xff, 0xff), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := NewBinaryCodec(&fakeConn{in: bytes.NewReader(tt.in)})
+			var req Request
+			err := codec.ReadRequestHeader(&req)
+			if tt.ok != (err == nil) {
+				t.Fatalf("ReadRequestHeader error = %v, want ok: %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	conn := &fakeConn{}
+	w := NewBinaryCodec(conn)
+	req := &Request{ServiceMethod: "Arith.Add", Seq: 42, Metadata: map[string]string{"auth": "t"}}
+	if err := w.WriteRequest(req, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewBinaryCodec(&fakeConn{in: &conn.out})
+	var got Request
+	if err := r.ReadRequestHeader(&got); err != nil {
+		t.Fatal(err)
+	}
+	var body []int
+	if err := r.ReadRequestBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if got.ServiceMethod != req.ServiceMethod || got.Seq != req.Seq || got.Metadata["auth"] != "t" {
+		t.Fatalf("header = %+v, want %+v", got, req)
+	}
+	if len(body) != 2 || body[0] != 1 || body[1] != 2 {
+		t.Fatalf("body = %v", body)
+	}
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+)
+
+type gobCodec struct {
+	conn io.ReadWriteCloser
+	dec  *gob.Decoder
+	enc  *gob.Encoder
+	buf  *bufio.Writer
+}
+
+// NewGobCodec returns a codec that writes each header and body as a gob
+// value. Both ends must agree on the body types up front.
+func NewGobCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &gobCodec{
+		conn: conn,
+		dec:  gob.NewDecoder(conn),
+		enc:  gob.NewEncoder(buf),
+		buf:  buf,
+	}
+}
+
+func (c *gobCodec) ReadRequestHeader(r *Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobCodec) ReadRequestBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobCodec) WriteRequest(r *Request, body any) error {
+	return c.write(r, body)
+}
+
+func (c *gobCodec) ReadResponseHeader(r *Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobCodec) ReadResponseBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobCodec) WriteResponse(r *Response, body any) error {
+	return c.write(r, body)
+}
+
+func (c *gobCodec) write(header, body any) error {
+	if err := c.enc.Encode(header); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *gobCodec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonRequest and jsonResponse are the one-line envelopes of the JSON
+// codec, e.g.
+//
+//	{"method":"Arith.Add","seq":1,"body":{"A":1,"B":2}}
+//	{"method":"Arith.Add","seq":1,"body":3}
+type jsonRequest struct {
+	Request
+	Body json.RawMessage `json:"body"`
+}
+
+type jsonResponse struct {
+	Response
+	Body json.RawMessage `json:"body"`
+}
+
+type jsonCodec struct {
+	conn io.ReadWriteCloser
+	dec  *json.Decoder
+	enc  *json.Encoder
+	buf  *bufio.Writer
+	body json.RawMessage // body of the last header read
+}
+
+// NewJSONCodec returns a codec that writes every header together with its
+// body as one line of JSON, which is easy to speak from other languages.
+func NewJSONCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &jsonCodec{
+		conn: conn,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+		buf:  buf,
+	}
+}
+
+func (c *jsonCodec) ReadRequestHeader(r *Request) error {
+	var msg jsonRequest
+	if err := c.dec.Decode(&msg); err != nil {
+		return err
+	}
+	*r = msg.Request
+	c.body = msg.Body
+	return nil
+}
+
+func (c *jsonCodec) ReadRequestBody(body any) error {
+	return c.readBody(body)
+}
+
+func (c *jsonCodec) WriteRequest(r *Request, body any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode body: %w", err)
+	}
+	return c.write(&jsonRequest{Request: *r, Body: raw})
+}
+
+func (c *jsonCodec) ReadResponseHeader(r *Response) error {
+	var msg jsonResponse
+	if err := c.dec.Decode(&msg); err != nil {
+		return err
+	}
+	*r = msg.Response
+	c.body = msg.Body
+	return nil
+}
+
+func (c *jsonCodec) ReadResponseBody(body any) error {
+	return c.readBody(body)
+}
+
+func (c *jsonCodec) WriteResponse(r *Response, body any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode body: %w", err)
+	}
+	return c.write(&jsonResponse{Response: *r, Body: raw})
+}
+
+func (c *jsonCodec) readBody(body any) error {
+	raw := c.body
+	c.body = nil
+	if body == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, body)
+}
+
+func (c *jsonCodec) write(msg any) error {
+	// Encode terminates every message with a newline
+	if err := c.enc.Encode(msg); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *jsonCodec) Close() error {
+	return c.conn.Close()
+}
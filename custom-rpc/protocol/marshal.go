@@ -0,0 +1,391 @@
+package protocol
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var errShortBuffer = errors.New("binary: unexpected end of data")
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// marshaling is how a type encodes itself, if it does.
+type marshaling int
+
+const (
+	noMarshaler marshaling = iota
+	binaryMarshaler
+	textMarshaler
+)
+
+// marshalerOf reports which method pair values of t are encoded with. The
+// methods may have pointer receivers, and both halves of a pair must be
+// there, so Marshal and Unmarshal always agree on the encoding.
+func marshalerOf(t reflect.Type) marshaling {
+	if t.Kind() == reflect.Pointer {
+		return noMarshaler // the pointer's nil marker comes first
+	}
+	pt := reflect.PointerTo(t)
+	switch {
+	case pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType):
+		return binaryMarshaler
+	case pt.Implements(textMarshalerType) && pt.Implements(textUnmarshalerType):
+		return textMarshaler
+	}
+	return noMarshaler
+}
+
+// Marshal returns the compact binary encoding of v used by the binary
+// codec. The encoding is not self-describing: the reader must decode into
+// the same type. Integers are varints, floats are little-endian IEEE 754,
+// strings, slices and maps are length-prefixed, struct fields are written
+// in declaration order (unexported ones are skipped), and pointers carry a
+// one-byte nil marker. Types implementing encoding.BinaryMarshaler and
+// BinaryUnmarshaler, such as time.Time, are written as length-prefixed
+// bytes, as are those implementing the encoding.TextMarshaler pair, such
+// as big.Int. Interfaces, channels and
+// funcs are not supported. Like Unmarshal, a top-level pointer is
+// followed, so a value and a pointer to it encode the same.
+func Marshal(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("binary: Marshal of nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	return appendValue(nil, rv)
+}
+
+// Unmarshal decodes data produced by Marshal into the value v points to.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("binary: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	d := &decoder{buf: data}
+	if err := d.value(rv.Elem()); err != nil {
+		return err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("binary: %d trailing bytes after %s", len(d.buf), rv.Elem().Type())
+	}
+	return nil
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if m := marshalerOf(v.Type()); m != noMarshaler {
+		if !v.CanAddr() {
+			// the methods may have pointer receivers
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			v = c
+		}
+		var data []byte
+		var err error
+		if m == binaryMarshaler {
+			data, err = v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		} else {
+			data, err = v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = binary.AppendUvarint(buf, uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return appendElems(buf, v)
+	case reflect.Array:
+		return appendElems(buf, v)
+	case reflect.Map:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if buf, err = appendValue(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	default:
+		return nil, fmt.Errorf("binary: unsupported type %s", v.Type())
+	}
+}
+
+func appendElems(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if buf, err = appendValue(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+func (d *decoder) varint() (int64, error) {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if n > len(d.buf) {
+		return nil, errShortBuffer
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// length reads a length prefix and sanity-checks it against the remaining
+// input, given that each element takes at least size bytes, so a corrupt
+// prefix cannot trigger a huge allocation. Elements that take no bytes are
+// only bounded by maxFrameSize.
+func (d *decoder) length(size int) (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxFrameSize || (size > 0 && n > uint64(len(d.buf)/size)) {
+		return 0, errShortBuffer
+	}
+	return int(n), nil
+}
+
+// minSize returns the fewest bytes a value of t is encoded in. It is zero
+// for types that encode to nothing, such as structs with no exported
+// fields, whatever their size in memory.
+func minSize(t reflect.Type) int {
+	if marshalerOf(t) != noMarshaler {
+		return 1 // the length prefix
+	}
+	switch t.Kind() {
+	case reflect.Float32:
+		return 4
+	case reflect.Float64:
+		return 8
+	case reflect.Array:
+		return t.Len() * minSize(t.Elem())
+	case reflect.Struct:
+		n := 0
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				n += minSize(t.Field(i).Type)
+			}
+		}
+		return n
+	default:
+		// a varint, a bool, a length prefix or a nil marker; unsupported
+		// types fail on the first element anyway
+		return 1
+	}
+}
+
+func (d *decoder) value(v reflect.Value) error {
+	if m := marshalerOf(v.Type()); m != noMarshaler {
+		n, err := d.length(1)
+		if err != nil {
+			return err
+		}
+		data, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		if m == binaryMarshaler {
+			return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+		}
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return fmt.Errorf("binary: %d overflows %s", x, v.Type())
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return fmt.Errorf("binary: %d overflows %s", x, v.Type())
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.bytes(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		n, err := d.length(1)
+		if err != nil {
+			return err
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := d.length(minSize(v.Type().Elem()))
+		if err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.bytes(n)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		return d.elems(v)
+	case reflect.Array:
+		return d.elems(v)
+	case reflect.Map:
+		n, err := d.length(minSize(v.Type().Key()) + minSize(v.Type().Elem()))
+		if err != nil {
+			return err
+		}
+		// entries that take no bytes pass the length check with any count,
+		// and there can only be one of them anyway, so the input bounds the
+		// size hint
+		m := reflect.MakeMapWithSize(v.Type(), min(n, len(d.buf)))
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.SetZero()
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.value(v.Elem())
+	default:
+		return fmt.Errorf("binary: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (d *decoder) elems(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := d.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y    int
+	private int
+	Label   string
+}
+
+type nested struct {
+	Name   string
+	Points []point
+	Tags   map[string]int
+	Next   *nested
+	When   time.Time
+	Grid   [2][2]uint8
+	Ratio  float32
+	Scale  float64
+	OK     bool
+	Data   []byte
+	Nested *point
+}
+
+// celsius marshals itself through pointer-receiver methods, as a fixed
+// four-byte value unlike the varint its kind would get.
+type celsius int32
+
+func (c *celsius) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint32(nil, uint32(*c)), nil
+}
+
+func (c *celsius) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return errShortBuffer
+	}
+	*c = celsius(binary.BigEndian.Uint32(data))
+	return nil
+}
+
+// hidden has no exported fields, so it encodes to nothing.
+type hidden struct{ n int }
+
+type reading struct {
+	Temp  celsius
+	Temps []celsius
+	Total *big.Int
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	when := time.Date(2024, 5, 1, 12, 30, 0, 42, time.UTC)
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"bool", true},
+		{"zero int", 0},
+		{"negative int", -1},
+		{"min int64", int64(math.MinInt64)},
+		{"max int64", int64(math.MaxInt64)},
+		{"max uint64", uint64(math.MaxUint64)},
+		{"int8", int8(-128)},
+		{"uint16", uint16(65535)},
+		{"float32", float32(1.5)},
+		{"float64 infinity", math.Inf(-1)},
+		{"empty string", ""},
+		{"string", "héllo"},
+		{"nil bytes", []byte(nil)},
+		{"bytes", []byte{0, 1, 0xff}},
+		{"ints", []int{1, -2, 300}},
+		{"strings", []string{"a", "", "c"}},
+		{"array", [3]int16{-1, 0, 1}},
+		{"map", map[string]int{"a": 1, "b": 2}},
+		{"struct", point{X: 1, Y: -2, Label: "p"}},
+		{"time", when},
+		{"empty structs", []struct{}{{}, {}, {}}},
+		{"structs without exported fields", []hidden{{}, {}}},
+		{"map of structs without exported fields", map[hidden]hidden{{}: {}}},
+		{"pointer-receiver marshaler", celsius(-40)},
+		{"big int", *big.NewInt(-12345)},
+		{"marshaler fields", reading{Temp: 21, Temps: []celsius{1, 2}, Total: new(big.Int).Lsh(big.NewInt(1), 100)}},
+		{
+			"nested",
+			nested{
+				Name:   "root",
+				Points: []point{{X: 1}, {Y: 2}},
+				Tags:   map[string]int{"x": 1},
+				// nil slices and maps decode as empty ones
+				Next:  &nested{Name: "child", Points: []point{}, Tags: map[string]int{}, When: when},
+				When:  when,
+				Grid:  [2][2]uint8{{1, 2}, {3, 4}},
+				Ratio: 0.25,
+				Scale: -1e300,
+				OK:    true,
+				Data:  []byte("data"),
+			},
+		},
+		{"request header", Request{Kind: KindWindowUpdate, ServiceMethod: "S.M", Seq: 7, Deadline: -1, Window: 3, Metadata: map[string]string{"k": "v"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			got := reflect.New(reflect.TypeOf(tt.v))
+			if err := Unmarshal(data, got.Interface()); err != nil {
+				t.Fatalf("Unmarshal % x: %v", data, err)
+			}
+			if !reflect.DeepEqual(got.Elem().Interface(), tt.v) {
+				t.Fatalf("round trip = %#v, want %#v", got.Elem().Interface(), tt.v)
+			}
+
+			// a pointer encodes the same as the value it points to; map
+			// order varies between runs, so compare what it decodes to
+			ptr := reflect.New(reflect.TypeOf(tt.v))
+			ptr.Elem().Set(reflect.ValueOf(tt.v))
+			viaPtr, err := Marshal(ptr.Interface())
+			if err != nil || len(viaPtr) != len(data) {
+				t.Fatalf("Marshal of a pointer = % x, %v; want % x", viaPtr, err, data)
+			}
+			got = reflect.New(reflect.TypeOf(tt.v))
+			if err := Unmarshal(viaPtr, got.Interface()); err != nil || !reflect.DeepEqual(got.Elem().Interface(), tt.v) {
+				t.Fatalf("Marshal of a pointer decodes to %#v, %v; want %#v", got.Elem().Interface(), err, tt.v)
+			}
+		})
+	}
+}
+
+func TestMarshalEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want []byte
+	}{
+		{"small uint", uint(5), []byte{5}},
+		{"two-byte uint", uint(300), []byte{0xac, 0x02}},
+		{"zigzag int", -3, []byte{5}},
+		{"bool", false, []byte{0}},
+		{"string", "ab", []byte{2, 'a', 'b'}},
+		{"float64", 1.0, []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{"nil pointer field", struct{ P *int }{}, []byte{0}},
+		{"unexported field skipped", point{X: 1, Y: 2, private: 9}, []byte{2, 4, 0}},
+		{"pointer-receiver marshaler", celsius(1), []byte{4, 0, 0, 0, 1}},
+		{"unaddressable marshaler field", struct{ C celsius }{2}, []byte{4, 0, 0, 0, 2}},
+		{"text marshaler", big.NewInt(-7), []byte{2, '-', '7'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Marshal(%v) = % x, want % x", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"nil pointer", (*int)(nil)},
+		{"channel", make(chan int)},
+		{"func", func() {}},
+		{"interface field", struct{ V any }{V: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if data, err := Marshal(tt.v); err == nil {
+				t.Fatalf("Marshal = % x, want an error", data)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	// 0xff continuation bytes forever: a varint longer than 64 bits
+	overlong := bytes.Repeat([]byte{0xff}, 11)
+	huge := binary.AppendUvarint(nil, math.MaxUint64)
+	overFrame := binary.AppendUvarint(nil, maxFrameSize+1)
+
+	tests := []struct {
+		name string
+		data []byte
+		into any
+	}{
+		{"empty int", nil, new(int)},
+		{"truncated varint", []byte{0x80}, new(int)},
+		{"overlong varint", overlong, new(int)},
+		{"overlong uvarint", overlong, new(uint64)},
+		{"int8 overflow", binary.AppendVarint(nil, 128), new(int8)},
+		{"uint8 overflow", binary.AppendUvarint(nil, 256), new(uint8)},
+		{"uint32 overflow", binary.AppendUvarint(nil, math.MaxUint32+1), new(uint32)},
+		{"empty bool", nil, new(bool)},
+		{"short float32", []byte{0, 0, 0}, new(float32)},
+		{"short float64", []byte{0, 0, 0, 0, 0, 0, 0}, new(float64)},
+
+		{"string longer than input", []byte{5, 'a', 'b'}, new(string)},
+		{"string with huge length", append(huge, 'a'), new(string)},
+		{"string with overlong length", overlong, new(string)},
+		{"bytes longer than input", []byte{3, 1}, new([]byte)},
+		{"bytes with huge length", huge, new([]byte)},
+		{"slice longer than input", []byte{3, 1, 2}, new([]int)},
+		{"slice with huge length", huge, new([]int)},
+		{"zero-size slice over frame limit", overFrame, new([]struct{})},
+		{"zero-size map key over frame limit", overFrame, new(map[struct{}]int)},
+		{"hidden slice over frame limit", overFrame, new([]hidden)},
+		{"slice longer than its minimum size allows", []byte{2, 1, 2, 0}, new([]point)},
+		{"map longer than its minimum size allows", []byte{2, 1, 2}, new(map[int]int)},
+		{"map longer than input", []byte{2, 1, 'a', 1}, new(map[string]int)},
+		{"map with huge length", huge, new(map[string]int)},
+		{"array too short", []byte{1, 2}, new([3]int)},
+		{"struct cut short", []byte{2}, new(point)},
+		{"pointer without marker", nil, new(*int)},
+		{"pointer without value", []byte{1}, new(*int)},
+		{"time too short", []byte{2, 1, 2}, new(time.Time)},
+		{"invalid time", []byte{2, 1, 2, 3}, new(time.Time)},
+
+		{"trailing bytes", []byte{1, 2}, new(int)},
+		{"request header cut short", []byte{1, 3, 'S', '.'}, new(Request)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal(tt.data, tt.into); err == nil {
+				t.Fatalf("Unmarshal(% x) into %T succeeded with %v", tt.data, tt.into, reflect.ValueOf(tt.into).Elem())
+			}
+		})
+	}
+}
+
+func TestMinSize(t *testing.T) {
+	tests := []struct {
+		v    any
+		want int
+	}{
+		{false, 1},
+		{int64(0), 1},
+		{float32(0), 4},
+		{float64(0), 8},
+		{"", 1},
+		{[]float64(nil), 1},
+		{map[string]int(nil), 1},
+		{(*point)(nil), 1},
+		{[3]float32{}, 12},
+		{struct{}{}, 0},
+		{hidden{}, 0},
+		{[4]hidden{}, 0},
+		{point{}, 3},
+		{celsius(0), 1},
+		{time.Time{}, 1},
+	}
+	for _, tt := range tests {
+		if got := minSize(reflect.TypeOf(tt.v)); got != tt.want {
+			t.Errorf("minSize(%T) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalTargets(t *testing.T) {
+	var n int
+	tests := []struct {
+		name string
+		into any
+	}{
+		{"nil", nil},
+		{"non-pointer", n},
+		{"nil pointer", (*int)(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unmarshal([]byte{0}, tt.into); err == nil {
+				t.Fatal("Unmarshal succeeded")
+			}
+		})
+	}
+}
+
+// TestUnmarshalShortBuffer checks that every truncation of a valid
+// encoding fails cleanly rather than panicking or decoding garbage.
+func TestUnmarshalShortBuffer(t *testing.T) {
+	v := nested{
+		Name:   "root",
+		Points: []point{{X: 1, Label: "a"}, {Y: 300}},
+		Tags:   map[string]int{"x": -1},
+		Next:   &nested{Name: "child"},
+		Data:   []byte("data"),
+		Nested: &point{X: 5},
+	}
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(data) {
+		var got nested
+		if err := Unmarshal(data[:n], &got); err == nil {
+			t.Fatalf("Unmarshal of the first %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
@@ -1,11 +1,45 @@
 package protocol
 
+// Kind tells what a frame is for. Requests flow from client to server and
+// responses the other way; not every kind is valid in both directions.
+type Kind uint8
+
+const (
+	KindCall         Kind = iota // request: invoke ServiceMethod with the args in the body; response: its reply
+	KindCancel                   // request: abort the call or stream with the same Seq; the body is empty
+	KindStreamOpen               // request: open a stream on ServiceMethod; the body holds its args, if any
+	KindStreamMsg                // both: one stream message in the body
+	KindStreamEnd                // request: the client is done sending; response: the stream is over and Error tells how
+	KindWindowUpdate             // both: the sender may send Window more messages on the stream; the body is empty
+)
+
+// InitialWindow is how many messages each side of a stream may send before
+// it has to wait for a window update from its peer.
+const InitialWindow = 32
+
+// Request is the header of a frame sent by the client. It is followed on
+// the wire by a body: the call's args, a stream message, or an empty
+// placeholder, so the server can decode it into the method's own type.
 type Request struct {
-	ServiceMethod string
-	Args          any
+	Kind          Kind   `json:"kind,omitempty"`
+	ServiceMethod string `json:"method"`
+	Seq           uint64 `json:"seq"`                // echoed back in the response so calls can share one connection
+	Deadline      int64  `json:"deadline,omitempty"` // caller's deadline in Unix nanoseconds; zero means none
+	Window        uint32 `json:"window,omitempty"`   // credit granted by KindWindowUpdate
+
+	Metadata map[string]string `json:"metadata,omitempty"` // caller-supplied key/value pairs, such as auth tokens
 }
 
+// Response is the header of a frame sent by the server. It is followed on
+// the wire by the reply or stream message, or by an empty placeholder when
+// there is none or the call failed. A failure is described by Code, Error
+// and Details; see SetError and Err.
 type Response struct {
-	Reply any
-	Error string
+	Kind          Kind   `json:"kind,omitempty"`
+	ServiceMethod string `json:"method"`
+	Seq           uint64 `json:"seq"`
+	Code          Code   `json:"code,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Details       []byte `json:"details,omitempty"`
+	Window        uint32 `json:"window,omitempty"`
 }
@@ -0,0 +1,53 @@
+package protocol
+
+// The built-in RPC service, registered on every server, describes the
+// server's methods so generic tools can call them without compiled stubs:
+//
+//	RPC.ListMethods(*ListMethodsArgs, *ListMethodsReply)
+//	RPC.Describe(*DescribeArgs, *MethodInfo)
+const ReflectionService = "RPC"
+
+type ListMethodsArgs struct {
+	Service string // only list this service's methods; empty lists all
+}
+
+type ListMethodsReply struct {
+	Methods []MethodInfo
+}
+
+type DescribeArgs struct {
+	Method string // "Service.Method"
+}
+
+// MethodInfo describes one registered method.
+type MethodInfo struct {
+	Name       string      // "Service.Method"
+	Stream     bool        // opened with KindStreamOpen rather than called with KindCall
+	Idempotent bool        // safe to call again after a failure; clients may retry it
+	Args       *TypeSchema // nil for streams that take no args
+	Reply      *TypeSchema // unary methods only
+
+	StreamIn  *TypeSchema // streams only: messages from the client
+	StreamOut *TypeSchema // streams only: messages from the server
+}
+
+// TypeSchema describes a Go type well enough to build or read its values
+// in another language, for instance as JSON for the json codec.
+type TypeSchema struct {
+	Name   string        // Go type name, such as "int" or "main.Args"; empty for unnamed composites
+	Kind   string        // reflect.Kind name, such as "struct", "slice", "map" or "int64"
+	Elem   *TypeSchema   // element of arrays, slices, maps and pointers
+	Key    *TypeSchema   // key of maps
+	Len    int           // length of arrays
+	Fields []FieldSchema // exported fields of structs
+
+	// Recursive is set on a named type already being described further up;
+	// it carries only Name and Kind to keep the schema finite.
+	Recursive bool
+}
+
+type FieldSchema struct {
+	Name string
+	Tag  string // the raw struct tag, which holds e.g. the field's JSON name
+	Type *TypeSchema
+}
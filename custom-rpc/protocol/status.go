@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Code classifies why a call failed, so callers can react without parsing
+// error text. Codes are stable on the wire; add new ones at the end.
+type Code uint32
+
+const (
+	CodeOK                 Code = iota // not an error
+	CodeCanceled                       // the caller canceled the call
+	CodeUnknown                        // the handler returned an error without a code
+	CodeInvalidArgument                // the args are malformed or out of range
+	CodeDeadlineExceeded               // the call's deadline passed before it finished
+	CodeNotFound                       // a requested entity does not exist
+	CodeAlreadyExists                  // an entity the call tried to create already exists
+	CodePermissionDenied               // the caller may not perform the call
+	CodeUnauthenticated                // the caller's credentials are missing or invalid
+	CodeResourceExhausted              // a quota or limit of the caller ran out
+	CodeFailedPrecondition             // the system is not in a state the call requires
+	CodeUnimplemented                  // the method does not exist or is not supported
+	CodeInternal                       // an invariant broke inside the server
+	CodeUnavailable                    // the service cannot be reached right now; retrying may help
+	CodeOverloaded                     // the server is too busy to take the call; back off and retry
+)
+
+var codeNames = [...]string{
+	CodeOK:                 "ok",
+	CodeCanceled:           "canceled",
+	CodeUnknown:            "unknown",
+	CodeInvalidArgument:    "invalid argument",
+	CodeDeadlineExceeded:   "deadline exceeded",
+	CodeNotFound:           "not found",
+	CodeAlreadyExists:      "already exists",
+	CodePermissionDenied:   "permission denied",
+	CodeUnauthenticated:    "unauthenticated",
+	CodeResourceExhausted:  "resource exhausted",
+	CodeFailedPrecondition: "failed precondition",
+	CodeUnimplemented:      "unimplemented",
+	CodeInternal:           "internal",
+	CodeUnavailable:        "unavailable",
+	CodeOverloaded:         "overloaded",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("code(%d)", uint32(c))
+}
+
+// Error is an error with a Code that survives the trip from a handler to
+// the caller. Handlers return it, usually through Errorf; callers get it
+// back from the client and can test it with errors.Is against the Err*
+// values or unpack it with errors.As.
+type Error struct {
+	Code    Code
+	Message string
+	Details []byte // optional payload for the caller, in whatever encoding the service documents
+}
+
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is an *Error with the same code and, if
+// target has one, the same message. Deadline and cancellation errors also
+// match their context counterparts.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case context.DeadlineExceeded:
+		return e.Code == CodeDeadlineExceeded
+	case context.Canceled:
+		return e.Code == CodeCanceled
+	}
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// Sentinels for errors.Is; they match any *Error with the same code.
+var (
+	ErrCanceled           = &Error{Code: CodeCanceled}
+	ErrInvalidArgument    = &Error{Code: CodeInvalidArgument}
+	ErrDeadlineExceeded   = &Error{Code: CodeDeadlineExceeded}
+	ErrNotFound           = &Error{Code: CodeNotFound}
+	ErrAlreadyExists      = &Error{Code: CodeAlreadyExists}
+	ErrPermissionDenied   = &Error{Code: CodePermissionDenied}
+	ErrUnauthenticated    = &Error{Code: CodeUnauthenticated}
+	ErrResourceExhausted  = &Error{Code: CodeResourceExhausted}
+	ErrFailedPrecondition = &Error{Code: CodeFailedPrecondition}
+	ErrUnimplemented      = &Error{Code: CodeUnimplemented}
+	ErrInternal           = &Error{Code: CodeInternal}
+	ErrUnavailable        = &Error{Code: CodeUnavailable}
+	ErrOverloaded         = &Error{Code: CodeOverloaded}
+)
+
+// CodeOf returns the code of err: its own if it is or wraps an *Error,
+// the matching code for context errors, and CodeUnknown otherwise.
+func CodeOf(err error) Code {
+	var e *Error
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	default:
+		return CodeUnknown
+	}
+}
+
+// SetError records err in the response header. If err is or wraps an
+// *Error, its code, message and details are sent as they are; any other
+// error is sent with its text and the code CodeOf picks for it.
+func (r *Response) SetError(err error) {
+	r.Code = CodeOf(err)
+	r.Error = err.Error()
+	r.Details = nil
+	var e *Error
+	if errors.As(err, &e) {
+		r.Error = e.Message
+		r.Details = e.Details
+	}
+}
+
+// Err rebuilds the error recorded by SetError, or returns nil.
+func (r *Response) Err() error {
+	if r.Code == CodeOK && r.Error == "" {
+		return nil
+	}
+	code := r.Code
+	if code == CodeOK {
+		code = CodeUnknown // a peer that only filled in the text
+	}
+	return &Error{Code: code, Message: r.Error, Details: r.Details}
+}
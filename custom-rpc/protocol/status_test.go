@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", Errorf(CodeNotFound, "no user %d", 7))
+	tests := []struct {
+		name   string
+		target error
+		want   bool
+	}{
+		{"same code", ErrNotFound, true},
+		{"same code and message", &Error{Code: CodeNotFound, Message: "no user 7"}, true},
+		{"other message", &Error{Code: CodeNotFound, Message: "no user 8"}, false},
+		{"other code", ErrInternal, false},
+		{"plain error", errors.New("not found"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(err, tt.target); got != tt.want {
+				t.Fatalf("errors.Is(%v, %v) = %v, want %v", err, tt.target, got, tt.want)
+			}
+		})
+	}
+
+	if !errors.Is(Errorf(CodeDeadlineExceeded, ""), context.DeadlineExceeded) {
+		t.Fatal("CodeDeadlineExceeded does not match context.DeadlineExceeded")
+	}
+	if !errors.Is(Errorf(CodeCanceled, ""), context.Canceled) {
+		t.Fatal("CodeCanceled does not match context.Canceled")
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{nil, CodeOK},
+		{errors.New("boom"), CodeUnknown},
+		{Errorf(CodeUnavailable, "down"), CodeUnavailable},
+		{fmt.Errorf("call: %w", ErrPermissionDenied), CodePermissionDenied},
+		{context.DeadlineExceeded, CodeDeadlineExceeded},
+		{fmt.Errorf("call: %w", context.Canceled), CodeCanceled},
+	}
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.want {
+			t.Errorf("CodeOf(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want *Error
+	}{
+		{"coded", &Error{Code: CodeNotFound, Message: "gone", Details: []byte{1, 2}}, &Error{Code: CodeNotFound, Message: "gone", Details: []byte{1, 2}}},
+		{"wrapped", fmt.Errorf("outer: %w", Errorf(CodeInternal, "inner")), &Error{Code: CodeInternal, Message: "inner"}},
+		{"plain", errors.New("boom"), &Error{Code: CodeUnknown, Message: "boom"}},
+		{"context", context.Canceled, &Error{Code: CodeCanceled, Message: "context canceled"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp Response
+			resp.SetError(tt.err)
+			got, ok := resp.Err().(*Error)
+			if !ok || got.Code != tt.want.Code || got.Message != tt.want.Message || string(got.Details) != string(tt.want.Details) {
+				t.Fatalf("Err() = %#v, want %#v", resp.Err(), tt.want)
+			}
+		})
+	}
+
+	if err := (&Response{}).Err(); err != nil {
+		t.Fatalf("Err() of a successful response = %v", err)
+	}
+	// a peer that only fills in the text still yields a coded error
+	if err := (&Response{Error: "old"}).Err(); CodeOf(err) != CodeUnknown || err.Error() != "unknown: old" {
+		t.Fatalf("Err() with text only = %v", err)
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	if got := CodeOverloaded.String(); got != "overloaded" {
+		t.Fatalf("CodeOverloaded = %q", got)
+	}
+	if got := Code(99).String(); got != "code(99)" {
+		t.Fatalf("Code(99) = %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// File is a registry stored as a JSON object mapping service names to
+// addresses, such as
+//
+//	{"Arith": ["10.0.0.1:9000", "10.0.0.2:9000"]}
+//
+// The file is read on every Resolve, so edits show up right away. Announce
+// and Withdraw rewrite it atomically, but concurrent writers in different
+// processes may lose each other's updates.
+type File struct {
+	path string
+	mu   sync.Mutex // serializes read-modify-write cycles in this process
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Resolve(ctx context.Context, service string) ([]string, error) {
+	entries, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	addrs := entries[service]
+	if len(addrs) == 0 {
+		return nil, protocol.Errorf(protocol.CodeNotFound, "no endpoints for service %q", service)
+	}
+	return addrs, nil
+}
+
+// Announce adds addr to the endpoints of service, if it is not there yet.
+func (f *File) Announce(service, addr string) error {
+	return f.update(func(entries map[string][]string) {
+		if !slices.Contains(entries[service], addr) {
+			entries[service] = append(entries[service], addr)
+		}
+	})
+}
+
+// Withdraw removes addr from the endpoints of service.
+func (f *File) Withdraw(service, addr string) error {
+	return f.update(func(entries map[string][]string) {
+		entries[service] = slices.DeleteFunc(entries[service], func(a string) bool { return a == addr })
+		if len(entries[service]) == 0 {
+			delete(entries, service)
+		}
+	})
+}
+
+func (f *File) update(fn func(map[string][]string)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := f.load()
+	if err != nil {
+		return err
+	}
+	fn(entries)
+	return f.store(entries)
+}
+
+// load reads the file; a missing file is an empty registry.
+func (f *File) load() (map[string][]string, error) {
+	entries := make(map[string][]string)
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", f.path, err)
+	}
+	return entries, nil
+}
+
+// store writes entries to a temporary file and renames it over the
+// registry, so readers never see a half-written file.
+func (f *File) store(entries map[string][]string) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode registry: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	return nil
+}
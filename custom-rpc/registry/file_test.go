@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	f := NewFile(path)
+	ctx := context.Background()
+
+	if _, err := f.Resolve(ctx, "Arith"); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Resolve on a missing file = %v, want ErrNotFound", err)
+	}
+
+	for _, addr := range []string{"a:1", "b:1", "a:1"} {
+		if err := f.Announce("Arith", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Announce("Other", "c:1"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := f.Resolve(ctx, "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"a:1", "b:1"}) {
+		t.Fatalf("Resolve = %q, %v; want [a:1 b:1]", addrs, err)
+	}
+
+	// another process sees the same file
+	addrs, err = NewFile(path).Resolve(ctx, "Other")
+	if err != nil || !slices.Equal(addrs, []string{"c:1"}) {
+		t.Fatalf("Resolve from a second File = %q, %v", addrs, err)
+	}
+
+	if err := f.Withdraw("Arith", "a:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Withdraw("Arith", "b:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Resolve(ctx, "Arith"); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Resolve after withdrawing every endpoint = %v, want ErrNotFound", err)
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("registry directory holds %d files, %v; want just the registry", len(entries), err)
+	}
+}
+
+func TestFileEditedByHand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	f := NewFile(path)
+
+	if err := os.WriteFile(path, []byte(`{"Arith": ["x:1"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := f.Resolve(context.Background(), "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"x:1"}) {
+		t.Fatalf("Resolve = %q, %v; want [x:1]", addrs, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"Arith": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Resolve(context.Background(), "Arith"); err == nil || errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Resolve of a corrupt file = %v, want a parse error", err)
+	}
+	if err := f.Announce("Arith", "y:1"); err == nil {
+		t.Fatal("Announce overwrote a corrupt registry")
+	}
+}
@@ -0,0 +1,17 @@
+// Package registry keeps track of where the replicas of each service
+// listen. Servers announce their address under a service name, and clients
+// resolve the name to every address currently announced.
+//
+// Two registries are provided: File, a JSON file shared by processes on
+// one host or written by deployment tooling, and Service, an RPC service
+// that servers announce themselves to over the network, reached from
+// clients through Remote.
+package registry
+
+import "context"
+
+// Resolver turns a service name into the addresses of its replicas. A name
+// with no replicas is reported as an error matching protocol.ErrNotFound.
+type Resolver interface {
+	Resolve(ctx context.Context, service string) ([]string, error)
+}
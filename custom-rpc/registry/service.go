@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// ServiceName is the name Service is expected to be registered under.
+const ServiceName = "Registry"
+
+// DefaultTTL is how long an announcement lasts when it does not say.
+const DefaultTTL = 30 * time.Second
+
+type AnnounceArgs struct {
+	Service string
+	Addr    string
+	TTL     time.Duration // how long the announcement lasts unless renewed
+}
+
+type WithdrawArgs struct {
+	Service string
+	Addr    string
+}
+
+type LookupArgs struct {
+	Service string
+}
+
+type LookupReply struct {
+	Addrs []string
+}
+
+// Service is a registry served over RPC:
+//
+//	s.Register(registry.ServiceName, registry.NewService())
+//
+// Announcements expire after their TTL, so servers that die without
+// withdrawing drop out on their own; live servers keep renewing theirs
+// with Remote.Heartbeat.
+type Service struct {
+	mu      sync.Mutex
+	entries map[string]map[string]time.Time // service -> addr -> expiry
+}
+
+func NewService() *Service {
+	return &Service{entries: make(map[string]map[string]time.Time)}
+}
+
+func (s *Service) Announce(ctx context.Context, args *AnnounceArgs, reply *struct{}) error {
+	if args.Service == "" || args.Addr == "" {
+		return protocol.Errorf(protocol.CodeInvalidArgument, "service and addr are required")
+	}
+	ttl := args.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := s.entries[args.Service]
+	if addrs == nil {
+		addrs = make(map[string]time.Time)
+		s.entries[args.Service] = addrs
+	}
+	if _, ok := addrs[args.Addr]; !ok {
+		slog.Info("endpoint announced", "service", args.Service, "addr", args.Addr)
+	}
+	addrs[args.Addr] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *Service) Withdraw(ctx context.Context, args *WithdrawArgs, reply *struct{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[args.Service][args.Addr]; ok {
+		slog.Info("endpoint withdrawn", "service", args.Service, "addr", args.Addr)
+		delete(s.entries[args.Service], args.Addr)
+	}
+	return nil
+}
+
+func (s *Service) Lookup(ctx context.Context, args *LookupArgs, reply *LookupReply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for addr, expiry := range s.entries[args.Service] {
+		if now.After(expiry) {
+			slog.Info("endpoint expired", "service", args.Service, "addr", addr)
+			delete(s.entries[args.Service], addr)
+			continue
+		}
+		reply.Addrs = append(reply.Addrs, addr)
+	}
+	if len(reply.Addrs) == 0 {
+		return protocol.Errorf(protocol.CodeNotFound, "no endpoints for service %q", args.Service)
+	}
+	sort.Strings(reply.Addrs)
+	return nil
+}
+
+// Remote talks to a Service through c.
+type Remote struct {
+	c *client.Client
+}
+
+func NewRemote(c *client.Client) *Remote {
+	return &Remote{c: c}
+}
+
+func (r *Remote) Resolve(ctx context.Context, service string) ([]string, error) {
+	var reply LookupReply
+	if err := r.c.Call(ctx, ServiceName+".Lookup", &LookupArgs{Service: service}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Addrs, nil
+}
+
+func (r *Remote) Announce(ctx context.Context, service, addr string, ttl time.Duration) error {
+	return r.c.Call(ctx, ServiceName+".Announce", &AnnounceArgs{Service: service, Addr: addr, TTL: ttl}, &struct{}{})
+}
+
+func (r *Remote) Withdraw(ctx context.Context, service, addr string) error {
+	return r.c.Call(ctx, ServiceName+".Withdraw", &WithdrawArgs{Service: service, Addr: addr}, &struct{}{})
+}
+
+// Heartbeat announces addr and renews it every third of ttl until ctx is
+// done, then withdraws it. Failed renewals are logged and retried on the
+// next beat, so a registry restart only drops the endpoint briefly.
+func (r *Remote) Heartbeat(ctx context.Context, service, addr string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		if err := r.Announce(ctx, service, addr, ttl); err != nil && ctx.Err() == nil {
+			slog.Warn("failed to announce endpoint", "service", service, "addr", addr, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			withdrawCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := r.Withdraw(withdrawCtx, service, addr); err != nil {
+				slog.Warn("failed to withdraw endpoint", "service", service, "addr", addr, "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+	"github.com/shahin-bayat/custom-rpc/server"
+)
+
+func newRemote(t *testing.T) *Remote {
+	t.Helper()
+	s := server.NewServer()
+	if err := s.Register(ServiceName, NewService()); err != nil {
+		t.Fatal(err)
+	}
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c, err := client.NewClient(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return NewRemote(c)
+}
+
+func TestRemote(t *testing.T) {
+	r := newRemote(t)
+	ctx := context.Background()
+
+	if _, err := r.Resolve(ctx, "Arith"); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Resolve of an unknown service = %v, want ErrNotFound", err)
+	}
+	for _, addr := range []string{"b:1", "a:1", "b:1"} {
+		if err := r.Announce(ctx, "Arith", addr, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addrs, err := r.Resolve(ctx, "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"a:1", "b:1"}) {
+		t.Fatalf("Resolve = %q, %v; want [a:1 b:1]", addrs, err)
+	}
+
+	if err := r.Withdraw(ctx, "Arith", "a:1"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err = r.Resolve(ctx, "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"b:1"}) {
+		t.Fatalf("Resolve after Withdraw = %q, %v; want [b:1]", addrs, err)
+	}
+
+	if err := r.Announce(ctx, "", "c:1", 0); !errors.Is(err, protocol.ErrInvalidArgument) {
+		t.Fatalf("Announce without a service = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestRemoteExpiry(t *testing.T) {
+	r := newRemote(t)
+	ctx := context.Background()
+
+	if err := r.Announce(ctx, "Arith", "a:1", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Announce(ctx, "Arith", "b:1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	addrs, err := r.Resolve(ctx, "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"b:1"}) {
+		t.Fatalf("Resolve after a:1 expired = %q, %v; want [b:1]", addrs, err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	r := newRemote(t)
+	const ttl = 60 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Heartbeat(ctx, "Arith", "a:1", ttl)
+		close(done)
+	}()
+
+	// renewals keep the endpoint alive well past its TTL
+	time.Sleep(3 * ttl)
+	addrs, err := r.Resolve(context.Background(), "Arith")
+	if err != nil || !slices.Equal(addrs, []string{"a:1"}) {
+		t.Fatalf("Resolve while beating = %q, %v; want [a:1]", addrs, err)
+	}
+
+	cancel()
+	<-done
+	if _, err := r.Resolve(context.Background(), "Arith"); !errors.Is(err, protocol.ErrNotFound) {
+		t.Fatalf("Resolve after the heartbeat stopped = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// Rule restricts the methods matching Pattern to the peers Allow accepts.
+// Pattern is "Service.Method", "Service.*" or "*".
+type Rule struct {
+	Pattern string
+	Allow   func(p *Peer) bool
+}
+
+func (r Rule) matches(method string) bool {
+	switch {
+	case r.Pattern == "*":
+		return true
+	case strings.HasSuffix(r.Pattern, ".*"):
+		return strings.HasPrefix(method, strings.TrimSuffix(r.Pattern, "*"))
+	default:
+		return r.Pattern == method
+	}
+}
+
+// RequireOU accepts peers whose verified certificate names one of the
+// given organizational units in its subject.
+func RequireOU(units ...string) func(*Peer) bool {
+	return func(p *Peer) bool {
+		if p.Certificate == nil {
+			return false
+		}
+		for _, ou := range p.Certificate.Subject.OrganizationalUnit {
+			if slices.Contains(units, ou) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RequireCN accepts peers whose verified certificate has one of the given
+// common names.
+func RequireCN(names ...string) func(*Peer) bool {
+	return func(p *Peer) bool {
+		return p.Certificate != nil && slices.Contains(names, p.Certificate.Subject.CommonName)
+	}
+}
+
+// authorize checks a call to method against every rule that matches it.
+// Methods no rule matches are open to everyone.
+func authorize(ctx context.Context, rules []Rule, method string) error {
+	p, _ := PeerFromContext(ctx)
+	if p == nil {
+		p = &Peer{}
+	}
+	for _, r := range rules {
+		if !r.matches(method) || r.Allow(p) {
+			continue
+		}
+		if p.Certificate == nil {
+			return protocol.Errorf(protocol.CodeUnauthenticated, "%s requires a client certificate", method)
+		}
+		return protocol.Errorf(protocol.CodePermissionDenied, "%s is not allowed for %q", method, p.Certificate.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/internal/flow"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// conn is the server side of one client connection.
+type conn struct {
+	server *Server
+	codec  protocol.Codec
+	ctx    context.Context // carries the Peer; canceled when the connection goes away
+	cancel context.CancelFunc
+
+	sending sync.Mutex // serializes writes to codec
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[uint64]context.CancelFunc // unary calls by Seq, for cancel frames
+	streams  map[uint64]*stream            // open streams by Seq
+}
+
+// newConn serves codec; peer is nil when there is no net.Conn behind it.
+func newConn(s *Server, codec protocol.Codec, peer *Peer) *conn {
+	ctx, cancel := context.WithCancel(withPeer(context.Background(), peer))
+	return &conn{
+		server:   s,
+		codec:    codec,
+		ctx:      ctx,
+		cancel:   cancel,
+		inflight: make(map[uint64]context.CancelFunc),
+		streams:  make(map[uint64]*stream),
+	}
+}
+
+func (c *conn) serve() {
+	if !c.server.trackConn(c, true) {
+		c.codec.Close() // shutting down
+		return
+	}
+	defer func() {
+		c.cancel()
+		c.wg.Wait() // let in-flight calls write their responses before closing
+		c.codec.Close()
+		c.server.trackConn(c, false)
+	}()
+
+	for {
+		var req protocol.Request
+		if err := c.codec.ReadRequestHeader(&req); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Error("failed to read request", "error", err)
+			}
+			return
+		}
+
+		var err error
+		switch req.Kind {
+		case protocol.KindCall:
+			err = c.handleCall(&req)
+		case protocol.KindCancel:
+			err = c.handleCancel(&req)
+		case protocol.KindStreamOpen:
+			err = c.handleStreamOpen(&req)
+		case protocol.KindStreamMsg:
+			err = c.handleStreamMsg(&req)
+		case protocol.KindStreamEnd:
+			err = c.handleStreamEnd(&req)
+		case protocol.KindWindowUpdate:
+			err = c.handleWindowUpdate(&req)
+		default:
+			// unknown frames are skipped so newer clients can still talk to us
+			slog.Warn("skipping unknown request kind", "kind", req.Kind, "seq", req.Seq)
+			err = c.codec.ReadRequestBody(nil)
+		}
+		if err != nil {
+			slog.Error("failed to read request body", "method", req.ServiceMethod, "error", err)
+			return
+		}
+	}
+}
+
+// handleCall reads the call's args and runs it in its own goroutine. It
+// only returns an error when the connection can no longer be read.
+func (c *conn) handleCall(req *protocol.Request) error {
+	resp := &protocol.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+
+	m, ok := c.server.lookup(req.ServiceMethod)
+	if !ok {
+		// the body still has to be consumed to stay in sync with the stream
+		if err := c.codec.ReadRequestBody(nil); err != nil {
+			return err
+		}
+		resp.SetError(protocol.Errorf(protocol.CodeUnimplemented, "unknown method %q", req.ServiceMethod))
+		c.send(resp, invalidBody)
+		return nil
+	}
+	if m.isStream() {
+		if err := c.codec.ReadRequestBody(nil); err != nil {
+			return err
+		}
+		resp.SetError(protocol.Errorf(protocol.CodeInvalidArgument, "%s is a streaming method", req.ServiceMethod))
+		c.send(resp, invalidBody)
+		return nil
+	}
+
+	args := m.newArgs()
+	if err := c.codec.ReadRequestBody(args.Interface()); err != nil {
+		slog.Error("failed to read request args", "method", req.ServiceMethod, "error", err)
+		resp.SetError(protocol.Errorf(protocol.CodeInvalidArgument, "%s: invalid args: %s", req.ServiceMethod, err))
+		c.send(resp, invalidBody)
+		return nil // codecs read whole messages, so the stream is still in sync
+	}
+
+	ctx, cancel := c.callContext(req)
+	if err := ctx.Err(); err != nil {
+		// expired on the way here; don't bother running it
+		cancel()
+		resp.SetError(err)
+		c.send(resp, invalidBody)
+		return nil
+	}
+	// checked under c.mu so Shutdown either sees the call or refuses it
+	c.mu.Lock()
+	if c.server.closing.Load() {
+		c.mu.Unlock()
+		cancel()
+		resp.SetError(errShuttingDown)
+		c.send(resp, invalidBody)
+		return nil
+	}
+	c.inflight[req.Seq] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, req.Seq)
+			c.mu.Unlock()
+			cancel()
+			c.wg.Done()
+		}()
+
+		reply := m.newReply()
+		if err := c.server.callUnary(ctx, req.ServiceMethod, m, args, reply); err != nil {
+			resp.SetError(err)
+			c.send(resp, invalidBody)
+			return
+		}
+		c.send(resp, reply.Interface())
+	}()
+	return nil
+}
+
+// handleCancel aborts the in-flight call or stream with the same Seq, if
+// any. It still sends its (now failed) response, which the client ignores.
+func (c *conn) handleCancel(req *protocol.Request) error {
+	if err := c.codec.ReadRequestBody(nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	cancel, ok := c.inflight[req.Seq]
+	st := c.streams[req.Seq]
+	c.mu.Unlock()
+	if ok {
+		slog.Info("call canceled by client", "method", req.ServiceMethod, "seq", req.Seq)
+		cancel()
+	}
+	if st != nil {
+		slog.Info("stream canceled by client", "method", req.ServiceMethod, "seq", req.Seq)
+		st.abort(context.Canceled)
+	}
+	return nil
+}
+
+// handleStreamOpen reads the stream's args, if its method takes any, and
+// runs the handler in its own goroutine. When the handler returns, the
+// client gets a KindStreamEnd frame carrying its error.
+func (c *conn) handleStreamOpen(req *protocol.Request) error {
+	end := &protocol.Response{Kind: protocol.KindStreamEnd, ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+
+	m, ok := c.server.lookup(req.ServiceMethod)
+	if !ok || !m.isStream() {
+		if err := c.codec.ReadRequestBody(nil); err != nil {
+			return err
+		}
+		if !ok {
+			end.SetError(protocol.Errorf(protocol.CodeUnimplemented, "unknown method %q", req.ServiceMethod))
+		} else {
+			end.SetError(protocol.Errorf(protocol.CodeInvalidArgument, "%s is not a streaming method", req.ServiceMethod))
+		}
+		c.send(end, invalidBody)
+		return nil
+	}
+
+	var args reflect.Value
+	if m.argType != nil {
+		args = m.newArgs()
+		if err := c.codec.ReadRequestBody(args.Interface()); err != nil {
+			slog.Error("failed to read stream args", "method", req.ServiceMethod, "error", err)
+			end.SetError(protocol.Errorf(protocol.CodeInvalidArgument, "%s: invalid args: %s", req.ServiceMethod, err))
+			c.send(end, invalidBody)
+			return nil
+		}
+	} else if err := c.codec.ReadRequestBody(nil); err != nil {
+		return err
+	}
+
+	callCtx, cancel := c.callContext(req)
+	ctx, abort := context.WithCancelCause(callCtx)
+	st := &stream{
+		conn:   c,
+		method: req.ServiceMethod,
+		seq:    req.Seq,
+		ctx:    ctx,
+		abort:  abort,
+		send:   flow.NewSendWindow(protocol.InitialWindow),
+	}
+	st.recv = flow.NewRecvQueue(protocol.InitialWindow, st.grant)
+	_, binder := m.newStream()
+	st.newIn = binder.newIn
+
+	c.mu.Lock()
+	if c.server.closing.Load() {
+		c.mu.Unlock()
+		abort(errStreamDone)
+		cancel()
+		end.SetError(errShuttingDown)
+		c.send(end, invalidBody)
+		return nil
+	}
+	c.streams[req.Seq] = st
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := c.server.callStream(ctx, req.ServiceMethod, m, args, st)
+		if err != nil && ctx.Err() != nil {
+			err = context.Cause(ctx) // says why the stream was cut short
+		}
+
+		c.mu.Lock()
+		delete(c.streams, req.Seq)
+		c.mu.Unlock()
+		st.send.Close(errStreamDone)
+		st.recv.Close(errStreamDone)
+		abort(errStreamDone)
+		cancel()
+
+		if err != nil {
+			end.SetError(err)
+		}
+		c.send(end, invalidBody)
+	}()
+	return nil
+}
+
+func (c *conn) handleStreamMsg(req *protocol.Request) error {
+	st := c.stream(req.Seq)
+	if st == nil {
+		// the handler is already gone; drop what the client still sent
+		return c.codec.ReadRequestBody(nil)
+	}
+	msg := st.newIn()
+	if err := c.codec.ReadRequestBody(msg); err != nil {
+		st.abort(protocol.Errorf(protocol.CodeInvalidArgument, "invalid stream message: %s", err))
+		return nil // codecs read whole messages, so the stream is still in sync
+	}
+	if err := st.recv.Push(msg); err != nil {
+		st.abort(protocol.Errorf(protocol.CodeResourceExhausted, "%s", err))
+	}
+	return nil
+}
+
+// handleStreamEnd marks the client's side of the stream as closed, so the
+// handler's Recv returns io.EOF once it has drained the queue.
+func (c *conn) handleStreamEnd(req *protocol.Request) error {
+	if err := c.codec.ReadRequestBody(nil); err != nil {
+		return err
+	}
+	if st := c.stream(req.Seq); st != nil {
+		st.recv.Close(io.EOF)
+	}
+	return nil
+}
+
+func (c *conn) handleWindowUpdate(req *protocol.Request) error {
+	if err := c.codec.ReadRequestBody(nil); err != nil {
+		return err
+	}
+	if st := c.stream(req.Seq); st != nil {
+		st.send.Grant(int(req.Window))
+	}
+	return nil
+}
+
+func (c *conn) stream(seq uint64) *stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[seq]
+}
+
+// callContext derives the handler's context from the connection, bounded
+// by the deadline the caller sent along and carrying its metadata.
+func (c *conn) callContext(req *protocol.Request) (context.Context, context.CancelFunc) {
+	ctx := withMetadata(c.ctx, req.Metadata)
+	if req.Deadline == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, time.Unix(0, req.Deadline))
+}
+
+// send writes a frame whose failure only needs to be logged: the read
+// loop notices a broken connection on its own.
+func (c *conn) send(resp *protocol.Response, body any) {
+	if err := c.write(resp, body); err != nil {
+		slog.Error("failed to write response", "method", resp.ServiceMethod, "error", err)
+	}
+}
+
+func (c *conn) write(resp *protocol.Response, body any) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.codec.WriteResponse(resp, body)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// UnaryHandler invokes a unary method, or the next interceptor in line.
+type UnaryHandler func(ctx context.Context, args, reply any) error
+
+// UnaryInterceptor runs around every unary call. It sees the method name,
+// the decoded args and the reply the method fills in. It may call next,
+// possibly with a different context, or return without calling it to
+// short-circuit the call; whatever it returns is what the client gets.
+type UnaryInterceptor func(ctx context.Context, method string, args, reply any, next UnaryHandler) error
+
+// ServerStream is the untyped view of a Stream that stream interceptors
+// see. An interceptor may wrap it to observe or alter the messages; the
+// handler's Stream reads and writes through the wrapper.
+type ServerStream interface {
+	Context() context.Context
+	SendMsg(msg any) error
+	RecvMsg() (any, error)
+}
+
+// StreamHandler invokes a streaming method, or the next interceptor in
+// line. args is nil for methods that take none.
+type StreamHandler func(ctx context.Context, args any, stream ServerStream) error
+
+// StreamInterceptor runs around every streaming call, like
+// UnaryInterceptor does around unary ones.
+type StreamInterceptor func(ctx context.Context, method string, args any, stream ServerStream, next StreamHandler) error
+
+// chainUnary wraps final in the interceptors, the first one outermost.
+func chainUnary(interceptors []UnaryInterceptor, method string, final UnaryHandler) UnaryHandler {
+	h := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, args, reply any) error {
+			return interceptor(ctx, method, args, reply, next)
+		}
+	}
+	return h
+}
+
+// chainStream wraps final in the interceptors, the first one outermost.
+func chainStream(interceptors []StreamInterceptor, method string, final StreamHandler) StreamHandler {
+	h := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, args any, stream ServerStream) error {
+			return interceptor(ctx, method, args, stream, next)
+		}
+	}
+	return h
+}
+
+// LoggingUnaryInterceptor logs every unary call with its duration and error.
+func LoggingUnaryInterceptor(ctx context.Context, method string, args, reply any, next UnaryHandler) error {
+	start := time.Now()
+	err := next(ctx, args, reply)
+	logCall(method, start, err)
+	return err
+}
+
+// LoggingStreamInterceptor logs every stream when it ends, with its
+// duration and error.
+func LoggingStreamInterceptor(ctx context.Context, method string, args any, stream ServerStream, next StreamHandler) error {
+	start := time.Now()
+	err := next(ctx, args, stream)
+	logCall(method, start, err)
+	return err
+}
+
+func logCall(method string, start time.Time, err error) {
+	if err != nil {
+		slog.Error("call failed", "method", method, "duration", time.Since(start), "error", err)
+		return
+	}
+	slog.Info("call served", "method", method, "duration", time.Since(start))
+}
+
+// RecoveryUnaryInterceptor turns a panic further down the chain into an
+// error. The server recovers from handler panics anyway; placing this
+// interceptor after others lets them see the panic as an ordinary error.
+func RecoveryUnaryInterceptor(ctx context.Context, method string, args, reply any, next UnaryHandler) (err error) {
+	defer recoverInto(method, &err)
+	return next(ctx, args, reply)
+}
+
+// RecoveryStreamInterceptor is the streaming counterpart of
+// RecoveryUnaryInterceptor.
+func RecoveryStreamInterceptor(ctx context.Context, method string, args any, stream ServerStream, next StreamHandler) (err error) {
+	defer recoverInto(method, &err)
+	return next(ctx, args, stream)
+}
+
+func recoverInto(method string, err *error) {
+	if r := recover(); r != nil {
+		slog.Error("panic in handler", "method", method, "panic", r)
+		*err = protocol.Errorf(protocol.CodeInternal, "panic in %s: %v", method, r)
+	}
+}
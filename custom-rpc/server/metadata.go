@@ -0,0 +1,19 @@
+package server
+
+import "context"
+
+type metadataKey struct{}
+
+// Metadata returns the key/value pairs the client attached to the call,
+// such as an auth token. It is nil when there are none.
+func Metadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+func withMetadata(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, metadataKey{}, md)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"reflect"
+)
+
+var (
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	contextType      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	streamBinderType = reflect.TypeOf((*streamBinder)(nil)).Elem()
+)
+
+// method is a registered service method of one of the forms
+//
+//	func (t *T) Name(ctx context.Context, args *Args, reply *Reply) error
+//	func (t *T) Name(ctx context.Context, args *Args, stream *Stream[In, Out]) error
+//	func (t *T) Name(ctx context.Context, stream *Stream[In, Out]) error
+type method struct {
+	fn         reflect.Value // bound to the service value
+	argType    reflect.Type  // Args, not *Args; nil for streams without args
+	replyType  reflect.Type  // Reply, not *Reply; nil for streams
+	streamType reflect.Type  // Stream[In, Out], not *Stream[In, Out]; nil for unary methods
+	idempotent bool          // marked with Idempotent at registration
+	slots      chan struct{} // one per running call when capped with MaxConcurrent; nil otherwise
+}
+
+func (m *method) isStream() bool {
+	return m.streamType != nil
+}
+
+// newArgs returns a fresh *Args to decode a request body into.
+func (m *method) newArgs() reflect.Value {
+	return reflect.New(m.argType)
+}
+
+// newReply returns a fresh *Reply with maps and slices already made, so
+// handlers can fill them in directly.
+func (m *method) newReply() reflect.Value {
+	reply := reflect.New(m.replyType)
+	switch m.replyType.Kind() {
+	case reflect.Map:
+		reply.Elem().Set(reflect.MakeMap(m.replyType))
+	case reflect.Slice:
+		reply.Elem().Set(reflect.MakeSlice(m.replyType, 0, 0))
+	}
+	return reply
+}
+
+// newStream returns a fresh *Stream[In, Out] for the handler together with
+// its binder, through which the connection drives it.
+func (m *method) newStream() (reflect.Value, streamBinder) {
+	st := reflect.New(m.streamType)
+	return st, st.Interface().(streamBinder)
+}
+
+// acquire takes a slot for a call, or reports false if all are taken.
+func (m *method) acquire() bool {
+	if m.slots == nil {
+		return true
+	}
+	select {
+	case m.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *method) release() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+func (m *method) call(ctx context.Context, in ...reflect.Value) error {
+	out := m.fn.Call(append([]reflect.Value{reflect.ValueOf(ctx)}, in...))
+	if err := out[0].Interface(); err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+// suitableMethods returns the methods of service that have the RPC shape,
+// keyed by "name.Method", and an error naming every method that does not.
+func suitableMethods(name string, service any) (map[string]*method, error) {
+	serviceType := reflect.TypeOf(service)
+	serviceValue := reflect.ValueOf(service)
+
+	methods := make(map[string]*method)
+	var errs []error
+	for i := 0; i < serviceType.NumMethod(); i++ {
+		m := serviceType.Method(i)
+		sig, err := checkSignature(m.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", name, m.Name, err))
+			continue
+		}
+		sig.fn = serviceValue.Method(i)
+		methods[fmt.Sprintf("%s.%s", name, m.Name)] = sig
+	}
+	return methods, errors.Join(errs...)
+}
+
+// checkSignature validates a method type taken from reflect.Type.Method,
+// whose first input is the receiver.
+func checkSignature(mtype reflect.Type) (*method, error) {
+	const want = "want (context.Context, *Args, *Reply), (context.Context, *Args, *Stream[In, Out]) or (context.Context, *Stream[In, Out])"
+
+	if mtype.NumOut() != 1 || mtype.Out(0) != errorType {
+		return nil, fmt.Errorf("must return exactly one error")
+	}
+	if mtype.NumIn() < 2 || mtype.In(1) != contextType {
+		return nil, fmt.Errorf("first argument must be context.Context; %s", want)
+	}
+
+	switch mtype.NumIn() {
+	case 3:
+		if !isStream(mtype.In(2)) {
+			return nil, fmt.Errorf("single argument %s is not a *Stream; %s", mtype.In(2), want)
+		}
+		return &method{streamType: mtype.In(2).Elem()}, nil
+	case 4:
+		argType, err := pointerElem(mtype.In(2))
+		if err != nil {
+			return nil, fmt.Errorf("args %w", err)
+		}
+		if isStream(mtype.In(3)) {
+			return &method{argType: argType, streamType: mtype.In(3).Elem()}, nil
+		}
+		replyType, err := pointerElem(mtype.In(3))
+		if err != nil {
+			return nil, fmt.Errorf("reply %w", err)
+		}
+		return &method{argType: argType, replyType: replyType}, nil
+	default:
+		return nil, fmt.Errorf("has %d arguments; %s", mtype.NumIn()-1, want)
+	}
+}
+
+func isStream(t reflect.Type) bool {
+	return t.Kind() == reflect.Pointer && t.Implements(streamBinderType)
+}
+
+func pointerElem(t reflect.Type) (reflect.Type, error) {
+	if t.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("type %s is not a pointer", t)
+	}
+	elem := t.Elem()
+	if !isExportedOrBuiltin(elem) {
+		return nil, fmt.Errorf("type %s is not exported", elem)
+	}
+	return elem, nil
+}
+
+func isExportedOrBuiltin(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return true
+	}
+	return token.IsExported(t.Name())
+}
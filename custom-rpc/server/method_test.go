@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type unexportedArgs struct{}
+
+type good struct{}
+
+func (good) Get(ctx context.Context, args *string, reply *[]string) error { return nil }
+
+type streams struct{}
+
+func (streams) Watch(ctx context.Context, args *string, stream *Stream[struct{}, string]) error {
+	return nil
+}
+func (streams) Chat(ctx context.Context, stream *Stream[string, string]) error { return nil }
+
+type tooManyArgs struct{}
+
+func (tooManyArgs) Get(ctx context.Context, args *string, reply *string, extra *string) error {
+	return nil
+}
+
+type streamNotPointer struct{}
+
+func (streamNotPointer) Get(ctx context.Context, args *string, stream Stream[string, string]) error {
+	return nil
+}
+
+type badContext struct{}
+
+func (badContext) Get(ctx string, args *string, reply *string) error { return nil }
+
+type badArgCount struct{}
+
+func (badArgCount) Get(ctx context.Context, args *string) error { return nil }
+
+type argsNotPointer struct{}
+
+func (argsNotPointer) Get(ctx context.Context, args string, reply *string) error { return nil }
+
+type replyNotPointer struct{}
+
+func (replyNotPointer) Get(ctx context.Context, args *string, reply string) error { return nil }
+
+type unexportedArgType struct{}
+
+func (unexportedArgType) Get(ctx context.Context, args *unexportedArgs, reply *string) error {
+	return nil
+}
+
+type noError struct{}
+
+func (noError) Get(ctx context.Context, args *string, reply *string) {}
+
+type twoResults struct{}
+
+func (twoResults) Get(ctx context.Context, args *string, reply *string) (int, error) { return 0, nil }
+
+type oneBad struct{}
+
+func (oneBad) Good(ctx context.Context, args *string, reply *string) error { return nil }
+func (oneBad) Bad(args *string) error                                      { return nil }
+
+type noMethods struct{}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		service any
+		err     string // a substring of the error; "" if Register succeeds
+	}{
+		{"valid", good{}, ""},
+		{"valid pointer receiver", &good{}, ""},
+		{"valid streams", streams{}, ""},
+		{"context not first", badContext{}, "first argument must be context.Context"},
+		{"single argument not a stream", badArgCount{}, "single argument *string is not a *Stream"},
+		{"too many arguments", tooManyArgs{}, "has 4 arguments"},
+		{"stream not a pointer", streamNotPointer{}, "reply type server.Stream[string,string] is not a pointer"},
+		{"args not a pointer", argsNotPointer{}, "args type string is not a pointer"},
+		{"reply not a pointer", replyNotPointer{}, "reply type string is not a pointer"},
+		{"unexported args", unexportedArgType{}, "is not exported"},
+		{"no error result", noError{}, "must return exactly one error"},
+		{"two results", twoResults{}, "must return exactly one error"},
+		{"one bad method", oneBad{}, "S.Bad"},
+		{"no methods", noMethods{}, "has no exported methods"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewServer().Register("S", tt.service)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Register: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Register error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRegisterAllOrNothing(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("S", oneBad{}); err == nil {
+		t.Fatal("Register succeeded")
+	}
+	if _, ok := s.methods["S.Good"]; ok {
+		t.Fatal("registered a method of a rejected service")
+	}
+	if err := NewServer().Register("", good{}); err == nil {
+		t.Fatal("Register with an empty name succeeded")
+	}
+
+	if err := s.Register("S", good{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("S", good{}); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Fatalf("registering twice: err = %v", err)
+	}
+}
+
+func TestNewReply(t *testing.T) {
+	methods, err := suitableMethods("S", good{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// handlers may append to a slice reply straight away
+	reply := methods["S.Get"].newReply().Interface().(*[]string)
+	if *reply == nil {
+		t.Fatal("slice reply is nil")
+	}
+}
+
+func TestRegisterIdempotent(t *testing.T) {
+	tests := []struct {
+		name    string
+		service any
+		methods []string
+		err     string
+	}{
+		{"unary", good{}, []string{"Get"}, ""},
+		{"unknown method", good{}, []string{"Put"}, "no method Put"},
+		{"stream", streams{}, []string{"Chat"}, "streaming method Chat cannot be idempotent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			err := s.Register("S", tt.service, Idempotent(tt.methods...))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Register error = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.methods["S.Get"].idempotent {
+				t.Fatal("S.Get is not marked idempotent")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import "context"
+
+type Option func(*Server)
+
+// WithUnaryInterceptors adds interceptors that run around every unary
+// call, in the order given.
+func WithUnaryInterceptors(interceptors ...UnaryInterceptor) Option {
+	return func(s *Server) {
+		s.unaryInterceptors = append(s.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds interceptors that run around every
+// streaming call, in the order given.
+func WithStreamInterceptors(interceptors ...StreamInterceptor) Option {
+	return func(s *Server) {
+		s.streamInterceptors = append(s.streamInterceptors, interceptors...)
+	}
+}
+
+// WithACL restricts methods to the peers the rules allow. A call must pass
+// every rule whose pattern matches its method; calls without a verified
+// client certificate fail with CodeUnauthenticated, others with
+// CodePermissionDenied. For example, to keep Admin to the ops team:
+//
+//	server.WithACL(server.Rule{Pattern: "Admin.*", Allow: server.RequireOU("ops")})
+//
+// The check runs as an interceptor, in the position of this option among
+// the other interceptor options.
+func WithACL(rules ...Rule) Option {
+	return func(s *Server) {
+		s.unaryInterceptors = append(s.unaryInterceptors, func(ctx context.Context, method string, args, reply any, next UnaryHandler) error {
+			if err := authorize(ctx, rules, method); err != nil {
+				return err
+			}
+			return next(ctx, args, reply)
+		})
+		s.streamInterceptors = append(s.streamInterceptors, func(ctx context.Context, method string, args any, stream ServerStream, next StreamHandler) error {
+			if err := authorize(ctx, rules, method); err != nil {
+				return err
+			}
+			return next(ctx, args, stream)
+		})
+	}
+}
+
+// RegisterOption configures the methods published by one Register call.
+type RegisterOption func(*registration)
+
+type registration struct {
+	idempotent []string
+	limits     map[string]int
+}
+
+// Idempotent marks the named methods (without the service prefix) as safe
+// to call more than once, which lets clients retry them after a failure.
+// Clients learn about it through RPC.ListMethods.
+func Idempotent(methods ...string) RegisterOption {
+	return func(r *registration) {
+		r.idempotent = append(r.idempotent, methods...)
+	}
+}
+
+// MaxConcurrent caps how many calls to the named method (without the
+// service prefix) may run at once. Calls beyond the cap fail right away
+// with CodeOverloaded, so one expensive method cannot take every worker
+// the server has. For streaming methods the cap counts open streams.
+func MaxConcurrent(method string, n int) RegisterOption {
+	return func(r *registration) {
+		if r.limits == nil {
+			r.limits = make(map[string]int)
+		}
+		r.limits[method] = n
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// Peer describes the client on the other end of a connection.
+type Peer struct {
+	Addr net.Addr
+	TLS  *tls.ConnectionState // nil for plaintext connections
+
+	// Certificate is the client's certificate, set only when it was
+	// verified against the server's tls.Config.ClientCAs.
+	Certificate *x509.Certificate
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer whose call ctx belongs to. It reports
+// false for calls that did not come over a net.Conn, such as those served
+// with ServeCodec or Invoke.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+func newPeer(c net.Conn) *Peer {
+	p := &Peer{Addr: c.RemoteAddr()}
+	if tc, ok := c.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		p.TLS = &state
+		if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+			p.Certificate = state.VerifiedChains[0][0]
+		}
+	}
+	return p
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// reflectionService implements the built-in protocol.ReflectionService.
+type reflectionService struct {
+	s *Server
+}
+
+// builtinMethods returns the method table a new server starts with.
+func builtinMethods(s *Server) map[string]*method {
+	methods, err := suitableMethods(protocol.ReflectionService, &reflectionService{s: s})
+	if err != nil {
+		panic("server: invalid reflection service: " + err.Error())
+	}
+	return methods
+}
+
+func (r *reflectionService) ListMethods(ctx context.Context, args *protocol.ListMethodsArgs, reply *protocol.ListMethodsReply) error {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	for name, m := range r.s.methods {
+		if args.Service != "" && !strings.HasPrefix(name, args.Service+".") {
+			continue
+		}
+		reply.Methods = append(reply.Methods, describeMethod(name, m))
+	}
+	sort.Slice(reply.Methods, func(i, j int) bool {
+		return reply.Methods[i].Name < reply.Methods[j].Name
+	})
+	return nil
+}
+
+func (r *reflectionService) Describe(ctx context.Context, args *protocol.DescribeArgs, reply *protocol.MethodInfo) error {
+	r.s.mu.RLock()
+	m, ok := r.s.methods[args.Method]
+	r.s.mu.RUnlock()
+	if !ok {
+		return protocol.Errorf(protocol.CodeNotFound, "unknown method %q", args.Method)
+	}
+	*reply = describeMethod(args.Method, m)
+	return nil
+}
+
+func describeMethod(name string, m *method) protocol.MethodInfo {
+	info := protocol.MethodInfo{
+		Name:       name,
+		Stream:     m.isStream(),
+		Idempotent: m.idempotent,
+	}
+	if m.argType != nil {
+		info.Args = describeType(m.argType, nil)
+	}
+	if m.replyType != nil {
+		info.Reply = describeType(m.replyType, nil)
+	}
+	if m.isStream() {
+		_, binder := m.newStream()
+		in, out := binder.messageTypes()
+		info.StreamIn = describeType(in, nil)
+		info.StreamOut = describeType(out, nil)
+	}
+	return info
+}
+
+// describeType builds the schema of t. visiting holds the named types
+// being described further up, to cut off recursive types.
+func describeType(t reflect.Type, visiting map[reflect.Type]bool) *protocol.TypeSchema {
+	schema := &protocol.TypeSchema{
+		Name: t.String(),
+		Kind: t.Kind().String(),
+	}
+	if t.Name() == "" {
+		schema.Name = ""
+	} else if visiting[t] {
+		schema.Recursive = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		schema.Len = t.Len()
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Slice, reflect.Pointer:
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Map:
+		schema.Key = describeType(t.Key(), visiting)
+		schema.Elem = describeType(t.Elem(), visiting)
+	case reflect.Struct:
+		if t.Name() != "" {
+			if visiting == nil {
+				visiting = make(map[reflect.Type]bool)
+			}
+			visiting[t] = true
+			defer delete(visiting, t)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			schema.Fields = append(schema.Fields, protocol.FieldSchema{
+				Name: f.Name,
+				Tag:  string(f.Tag),
+				Type: describeType(f.Type, visiting),
+			})
+		}
+	}
+	return schema
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+type Node struct {
+	Val  int `json:"val"`
+	Next *Node
+	Kids map[string][]Node
+	hide int
+}
+
+type Event struct{ N int }
+
+type tree struct{}
+
+func (tree) Get(ctx context.Context, args *Node, reply *[]string) error { return nil }
+
+func (tree) Watch(ctx context.Context, args *int, stream *Stream[struct{}, Event]) error {
+	return nil
+}
+
+// pipeClient connects a client to s over an in-memory connection.
+func pipeClient(t *testing.T, s *Server, opts ...client.Option) *client.Client {
+	t.Helper()
+	cc, sc := net.Pipe()
+	go s.ServeConn(sc)
+	c, err := client.NewClient(cc, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestListMethods(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("Tree", tree{}); err != nil {
+		t.Fatal(err)
+	}
+	c := pipeClient(t, s)
+
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{"", []string{"RPC.Describe", "RPC.ListMethods", "Tree.Get", "Tree.Watch"}},
+		{"Tree", []string{"Tree.Get", "Tree.Watch"}},
+		{"Tre", nil},
+	}
+	for _, tt := range tests {
+		var reply protocol.ListMethodsReply
+		if err := c.Call(context.Background(), "RPC.ListMethods", &protocol.ListMethodsArgs{Service: tt.service}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, m := range reply.Methods {
+			names = append(names, m.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("ListMethods(%q) = %q, want %q", tt.service, names, tt.want)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("Tree", tree{}, Idempotent("Get")); err != nil {
+		t.Fatal(err)
+	}
+	for _, codec := range protocol.CodecNames() {
+		t.Run(codec, func(t *testing.T) {
+			c := pipeClient(t, s, client.WithCodec(codec))
+
+			var get protocol.MethodInfo
+			if err := c.Call(context.Background(), "RPC.Describe", &protocol.DescribeArgs{Method: "Tree.Get"}, &get); err != nil {
+				t.Fatal(err)
+			}
+			node := &protocol.TypeSchema{Name: "server.Node", Kind: "struct", Recursive: true}
+			want := protocol.MethodInfo{
+				Name:       "Tree.Get",
+				Idempotent: true,
+				Args: &protocol.TypeSchema{Name: "server.Node", Kind: "struct", Fields: []protocol.FieldSchema{
+					{Name: "Val", Tag: `json:"val"`, Type: &protocol.TypeSchema{Name: "int", Kind: "int"}},
+					{Name: "Next", Type: &protocol.TypeSchema{Kind: "ptr", Elem: node}},
+					{Name: "Kids", Type: &protocol.TypeSchema{
+						Kind: "map",
+						Key:  &protocol.TypeSchema{Name: "string", Kind: "string"},
+						Elem: &protocol.TypeSchema{Kind: "slice", Elem: node},
+					}},
+				}},
+				Reply: &protocol.TypeSchema{Kind: "slice", Elem: &protocol.TypeSchema{Name: "string", Kind: "string"}},
+			}
+			if !sameInfo(get, want) {
+				got, _ := json.Marshal(get)
+				exp, _ := json.Marshal(want)
+				t.Fatalf("Describe(Tree.Get) =\n%s\nwant\n%s", got, exp)
+			}
+
+			var watch protocol.MethodInfo
+			if err := c.Call(context.Background(), "RPC.Describe", &protocol.DescribeArgs{Method: "Tree.Watch"}, &watch); err != nil {
+				t.Fatal(err)
+			}
+			if !watch.Stream || watch.Args.Kind != "int" || watch.Reply != nil ||
+				watch.StreamIn.Kind != "struct" || watch.StreamOut.Name != "server.Event" {
+				t.Fatalf("Describe(Tree.Watch) = %+v", watch)
+			}
+
+			var info protocol.MethodInfo
+			err := c.Call(context.Background(), "RPC.Describe", &protocol.DescribeArgs{Method: "Tree.Nope"}, &info)
+			if !errors.Is(err, protocol.ErrNotFound) {
+				t.Fatalf("Describe of an unknown method: err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestReflectionServiceIsReserved(t *testing.T) {
+	if err := NewServer().Register(protocol.ReflectionService, &reflectionService{}); err == nil {
+		t.Fatal("registering a second reflection service succeeded")
+	}
+}
+
+// sameInfo compares through the binary encoding, which does not tell nil
+// slices from empty ones; not every codec keeps them apart.
+func sameInfo(a, b protocol.MethodInfo) bool {
+	x, err1 := protocol.Marshal(a)
+	y, err2 := protocol.Marshal(b)
+	return err1 == nil && err2 == nil && bytes.Equal(x, y)
+}
@@ -1,26 +1,277 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// handshakeTimeout bounds the TLS and codec handshakes of a new connection.
+const handshakeTimeout = 10 * time.Second
+
+// Serve waits between acceptMinDelay and acceptMaxDelay, doubling each
+// time, before retrying a failed Accept.
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
 )
 
+// invalidBody is sent in place of a reply when the call failed.
+var invalidBody = struct{}{}
+
+// Server dispatches calls to the methods of registered services. Besides
+// those, every server answers the built-in protocol.ReflectionService,
+// which lists and describes them.
 type Server struct {
-	methods map[string]reflect.Value
+	methods map[string]*method
+	mu      sync.RWMutex
+
+	unaryInterceptors  []UnaryInterceptor
+	streamInterceptors []StreamInterceptor
+
+	closing     atomic.Bool // Shutdown has been called
+	connMu      sync.Mutex  // guards listeners, handshaking and conns
+	listeners   map[net.Listener]struct{}
+	handshaking map[net.Conn]struct{} // accepted, not yet serving calls
+	conns       map[*conn]struct{}
 }
 
-func (s *Server) Register(name string, service any) {
+func NewServer(opts ...Option) *Server {
+	s := &Server{}
+	s.methods = builtinMethods(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register publishes the methods of service under name. Every exported
+// method must have one of the forms
+//
+//	func (t *T) Method(ctx context.Context, args *Args, reply *Reply) error
+//	func (t *T) Method(ctx context.Context, args *Args, stream *Stream[In, Out]) error
+//	func (t *T) Method(ctx context.Context, stream *Stream[In, Out]) error
+//
+// otherwise nothing is registered and the returned error lists each
+// offending method. opts, such as Idempotent, apply to this service only.
+func (s *Server) Register(name string, service any, opts ...RegisterOption) error {
+	if name == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	methods, err := suitableMethods(name, service)
+	if err != nil {
+		return fmt.Errorf("failed to register %s: %w", name, err)
+	}
+	if len(methods) == 0 {
+		return fmt.Errorf("failed to register %s: type %T has no exported methods", name, service)
+	}
+	var reg registration
+	for _, opt := range opts {
+		opt(&reg)
+	}
+	for _, methodName := range reg.idempotent {
+		m, ok := methods[name+"."+methodName]
+		if !ok {
+			return fmt.Errorf("failed to register %s: no method %s to mark idempotent", name, methodName)
+		}
+		if m.isStream() {
+			return fmt.Errorf("failed to register %s: streaming method %s cannot be idempotent", name, methodName)
+		}
+		m.idempotent = true
+	}
+	for methodName, n := range reg.limits {
+		m, ok := methods[name+"."+methodName]
+		if !ok {
+			return fmt.Errorf("failed to register %s: no method %s to limit", name, methodName)
+		}
+		if n <= 0 {
+			return fmt.Errorf("failed to register %s: limit for %s must be positive", name, methodName)
+		}
+		m.slots = make(chan struct{}, n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.methods == nil {
-		s.methods = make(map[string]reflect.Value)
+		s.methods = builtinMethods(s)
+	}
+	for methodName := range methods {
+		if _, ok := s.methods[methodName]; ok {
+			return fmt.Errorf("failed to register %s: method %s already registered", name, methodName)
+		}
 	}
+	for methodName, m := range methods {
+		s.methods[methodName] = m
+	}
+	return nil
+}
 
-	serviceType := reflect.TypeOf(service)
-	serviceValue := reflect.ValueOf(service)
+// Serve accepts connections on ln and serves each one in its own goroutine.
+// It returns when the listener is closed, with ErrServerClosed if that was
+// done by Shutdown. Other accept errors, such as running out of file
+// descriptors, are retried after a growing delay.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.trackListener(ln, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
 
-	for i := 0; i < serviceType.NumMethod(); i++ {
-		method := serviceType.Method(i)
-		value := serviceValue.Method(i)
-		methodName := fmt.Sprintf("%s.%s", name, method.Name)
-		s.methods[methodName] = value
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				slog.Info("stopping server; listener closed")
+				return nil
+			}
+			delay = min(max(2*delay, acceptMinDelay), acceptMaxDelay)
+			slog.Warn("accept failed; retrying", "error", err, "delay", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		slog.Info("connection accepted", "remote", conn.RemoteAddr())
+		go s.ServeConn(conn)
 	}
 }
+
+// ServeTLS is like Serve, but runs TLS over the accepted connections. To
+// require client certificates, set config.ClientAuth to
+// tls.RequireAndVerifyClientCert and config.ClientCAs to the CAs that
+// issue them; handlers then find the verified certificate through
+// PeerFromContext.
+func (s *Server) ServeTLS(ln net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(ln, config))
+}
+
+// ServeConn negotiates a codec with the client on c and then serves it
+// like ServeCodec. If c is a *tls.Conn, the TLS handshake is completed
+// first. Both handshakes together must finish within handshakeTimeout.
+func (s *Server) ServeConn(c net.Conn) {
+	codec, err := s.handshake(c)
+	if err != nil {
+		slog.Error("handshake failed", "remote", c.RemoteAddr(), "error", err)
+		c.Close()
+		return
+	}
+	newConn(s, codec, newPeer(c)).serve()
+}
+
+// handshake runs the TLS and codec handshakes on c. Until it returns, c is
+// tracked so that Shutdown can close it.
+func (s *Server) handshake(c net.Conn) (protocol.Codec, error) {
+	if !s.trackHandshake(c, true) {
+		return nil, ErrServerClosed
+	}
+	defer s.trackHandshake(c, false)
+
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+	}
+	codec, err := protocol.ServerHandshake(c)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return codec, nil
+}
+
+// ServeCodec reads requests from codec and handles each of them in its own
+// goroutine, so a slow method does not hold up the rest of the connection.
+// Responses carry the request's Seq and may be written out of order.
+func (s *Server) ServeCodec(codec protocol.Codec) {
+	newConn(s, codec, nil).serve()
+}
+
+// Invoke calls a registered unary method on behalf of a transport other
+// than the native protocol, such as the jsonrpc gateway. decode fills in
+// the method's args, given a pointer to a fresh Args value. The call goes
+// through the server's interceptors like any other, and the reply comes
+// back as a pointer to the method's Reply type.
+func (s *Server) Invoke(ctx context.Context, serviceMethod string, decode func(args any) error) (any, error) {
+	m, ok := s.lookup(serviceMethod)
+	if !ok {
+		return nil, protocol.Errorf(protocol.CodeUnimplemented, "unknown method %q", serviceMethod)
+	}
+	if m.isStream() {
+		return nil, protocol.Errorf(protocol.CodeInvalidArgument, "%s is a streaming method", serviceMethod)
+	}
+	args := m.newArgs()
+	if err := decode(args.Interface()); err != nil {
+		return nil, protocol.Errorf(protocol.CodeInvalidArgument, "%s: invalid args: %s", serviceMethod, err)
+	}
+	reply := m.newReply()
+	if err := s.callUnary(ctx, serviceMethod, m, args, reply); err != nil {
+		return nil, err
+	}
+	return reply.Interface(), nil
+}
+
+func (s *Server) lookup(serviceMethod string) (*method, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.methods[serviceMethod]
+	return m, ok
+}
+
+// callUnary runs a unary method behind the server's interceptors.
+func (s *Server) callUnary(ctx context.Context, serviceMethod string, m *method, args, reply reflect.Value) error {
+	if !m.acquire() {
+		return protocol.Errorf(protocol.CodeOverloaded, "too many concurrent calls to %s", serviceMethod)
+	}
+	defer m.release()
+	handler := func(ctx context.Context, args, reply any) error {
+		return m.call(ctx, reflect.ValueOf(args), reflect.ValueOf(reply))
+	}
+	return s.recover(serviceMethod, func() error {
+		return chainUnary(s.unaryInterceptors, serviceMethod, handler)(ctx, args.Interface(), reply.Interface())
+	})
+}
+
+// callStream runs a streaming method behind the server's interceptors.
+// args is the zero Value for methods that take none.
+func (s *Server) callStream(ctx context.Context, serviceMethod string, m *method, args reflect.Value, stream ServerStream) error {
+	if !m.acquire() {
+		return protocol.Errorf(protocol.CodeOverloaded, "too many open streams on %s", serviceMethod)
+	}
+	defer m.release()
+	handler := func(ctx context.Context, args any, stream ServerStream) error {
+		var in []reflect.Value
+		if m.argType != nil {
+			in = append(in, reflect.ValueOf(args))
+		}
+		streamValue, binder := m.newStream()
+		binder.bind(stream)
+		return m.call(ctx, append(in, streamValue)...)
+	}
+	var argsIface any
+	if args.IsValid() {
+		argsIface = args.Interface()
+	}
+	return s.recover(serviceMethod, func() error {
+		return chainStream(s.streamInterceptors, serviceMethod, handler)(ctx, argsIface, stream)
+	})
+}
+
+// recover turns a panic in fn into an error, so a panicking handler or
+// interceptor does not take the whole server down with it.
+func (s *Server) recover(serviceMethod string, fn func() error) (err error) {
+	defer recoverInto(serviceMethod, &err)
+	return fn()
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+type Pair struct{ A, B int }
+
+type arith struct{}
+
+func (arith) Div(ctx context.Context, args *Pair, reply *int) error {
+	if args.A < 0 {
+		return protocol.Errorf(protocol.CodeInvalidArgument, "negative dividend %d", args.A)
+	}
+	if args.B == 0 {
+		return errors.New("division by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (arith) Boom(ctx context.Context, args *Pair, reply *int) error { panic("boom") }
+
+func TestServeConn(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("Arith", arith{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+
+	for _, name := range protocol.CodecNames() {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, err := protocol.ClientHandshake(conn, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer codec.Close()
+			testServeCodec(t, codec)
+		})
+	}
+
+	ln.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve returned %v after the listener was closed, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the listener was closed")
+	}
+}
+
+// flakyListener fails its first failures Accept calls, like a listener
+// that has run out of file descriptors.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, errors.New("accept: too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeRetriesAccept(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("Arith", arith{}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(&flakyListener{Listener: ln, failures: 5}) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	codec, err := protocol.ClientHandshake(conn, "gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.Close()
+	testServeCodec(t, codec)
+
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned %v after a failed Accept, want it to keep serving", err)
+	default:
+	}
+}
+
+func testServeCodec(t *testing.T, codec protocol.Codec) {
+	tests := []struct {
+		name   string
+		method string
+		args   any
+		reply  int
+		code   protocol.Code
+		err    string // a substring of the response error
+	}{
+		{"call", "Arith.Div", Pair{9, 3}, 3, protocol.CodeOK, ""},
+		{"returned error", "Arith.Div", Pair{1, 0}, 0, protocol.CodeUnknown, "division by zero"},
+		{"coded error", "Arith.Div", Pair{-1, 1}, 0, protocol.CodeInvalidArgument, "negative dividend"},
+		{"unknown method", "Arith.Nope", Pair{1, 1}, 0, protocol.CodeUnimplemented, "unknown method"},
+		{"wrong args type", "Arith.Div", "not a pair", 0, protocol.CodeInvalidArgument, "invalid args"},
+		{"panic", "Arith.Boom", Pair{}, 0, protocol.CodeInternal, "panic in Arith.Boom"},
+		// the connection is still in sync after each failure above
+		{"after failures", "Arith.Div", Pair{8, 2}, 4, protocol.CodeOK, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := uint64(i)
+			if err := codec.WriteRequest(&protocol.Request{ServiceMethod: tt.method, Seq: seq}, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			var resp protocol.Response
+			if err := codec.ReadResponseHeader(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Seq != seq || resp.ServiceMethod != tt.method {
+				t.Fatalf("response header = %+v, want seq %d for %s", resp, seq, tt.method)
+			}
+			if tt.err != "" {
+				if err := codec.ReadResponseBody(nil); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Code != tt.code || !strings.Contains(resp.Error, tt.err) {
+					t.Fatalf("error = %s %q, want %s containing %q", resp.Code, resp.Error, tt.code, tt.err)
+				}
+				return
+			}
+			var reply int
+			if err := codec.ReadResponseBody(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error != "" || reply != tt.reply {
+				t.Fatalf("reply = %d, %q; want %d", reply, resp.Error, tt.reply)
+			}
+		})
+	}
+}
+
+func TestServeConnFrames(t *testing.T) {
+	s := NewServer()
+	if err := s.Register("Arith", arith{}); err != nil {
+		t.Fatal(err)
+	}
+	client, srv := net.Pipe()
+	go s.ServeConn(srv)
+	codec, err := protocol.ClientHandshake(client, protocol.DefaultCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.Close()
+
+	// a frame of a kind this server does not know is skipped
+	if err := codec.WriteRequest(&protocol.Request{Kind: 99, Seq: 1}, Pair{}); err != nil {
+		t.Fatal(err)
+	}
+	// a call whose deadline already passed is not run
+	expired := &protocol.Request{ServiceMethod: "Arith.Div", Seq: 2, Deadline: 1}
+	if err := codec.WriteRequest(expired, Pair{4, 2}); err != nil {
+		t.Fatal(err)
+	}
+	var resp protocol.Response
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seq != 2 || resp.Code != protocol.CodeDeadlineExceeded {
+		t.Fatalf("response = %+v, want seq 2 failing with deadline exceeded", resp)
+	}
+	if err := codec.ReadResponseBody(nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// ErrServerClosed is returned by Serve once Shutdown has been called.
+var ErrServerClosed = errors.New("server closed")
+
+// errShuttingDown refuses calls that arrive while the server drains.
+var errShuttingDown = protocol.Errorf(protocol.CodeUnavailable, "server is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks for in-flight calls.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown stops the server gracefully. It closes every listener passed to
+// Serve and every connection still in its handshake, refuses new calls on
+// open connections with CodeUnavailable so clients can take them
+// elsewhere, and waits for the calls and streams in flight to finish
+// before closing the connections. If ctx is done first, the connections
+// are closed anyway, which cancels the calls still running, and ctx's
+// error is returned.
+//
+// Calls made through Invoke are not tracked; shut down the transport that
+// makes them, such as an http.Server, first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	s.connMu.Lock()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.handshaking {
+		c.Close() // no calls on it yet, so nothing to drain
+	}
+	s.connMu.Unlock()
+
+	err := s.drain(ctx)
+
+	s.connMu.Lock()
+	for c := range s.conns {
+		c.codec.Close()
+	}
+	s.connMu.Unlock()
+	return err
+}
+
+// drain waits until no calls are in flight or ctx is done.
+func (s *Server) drain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for s.activeCalls() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *Server) activeCalls() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	n := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		n += len(c.inflight) + len(c.streams)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+// trackListener adds or removes ln from the listeners Shutdown closes. It
+// reports false if the server is already shutting down.
+func (s *Server) trackListener(ln net.Listener, add bool) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if !add {
+		delete(s.listeners, ln)
+		return true
+	}
+	if s.closing.Load() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+// trackHandshake is trackListener for connections that have been accepted
+// but are still negotiating TLS or a codec.
+func (s *Server) trackHandshake(c net.Conn, add bool) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if !add {
+		delete(s.handshaking, c)
+		return true
+	}
+	if s.closing.Load() {
+		return false
+	}
+	if s.handshaking == nil {
+		s.handshaking = make(map[net.Conn]struct{})
+	}
+	s.handshaking[c] = struct{}{}
+	return true
+}
+
+// trackConn is trackListener for connections.
+func (s *Server) trackConn(c *conn, add bool) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.closing.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// gated blocks each call to Wait until it is let through, or until the
+// call's context is done.
+type gated struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newGated() *gated {
+	return &gated{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (g *gated) Wait(ctx context.Context, args *int, reply *int) error {
+	g.started <- struct{}{}
+	select {
+	case <-g.release:
+		*reply = *args
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *gated) Now(ctx context.Context, args *int, reply *int) error {
+	*reply = *args
+	return nil
+}
+
+// startGated serves g on a loopback listener and returns a client for it
+// along with Serve's result.
+func startGated(t *testing.T, s *Server, g *gated, opts ...RegisterOption) (*client.Client, chan error) {
+	t.Helper()
+	if err := s.Register("Gated", g, opts...); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	c, err := client.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, served
+}
+
+func TestShutdown(t *testing.T) {
+	s := NewServer()
+	g := newGated()
+	c, served := startGated(t, s, g)
+
+	var reply int
+	inflight := c.Go(context.Background(), "Gated.Wait", 7, &reply, nil)
+	<-g.started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Serve = %v, want ErrServerClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+	// new calls are refused while the server drains
+	var n int
+	if err := c.Call(context.Background(), "Gated.Now", 1, &n); !errors.Is(err, protocol.ErrUnavailable) {
+		t.Fatalf("call during shutdown = %v, want ErrUnavailable", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a call in flight", err)
+	default:
+	}
+
+	close(g.release)
+	<-inflight.Done
+	if inflight.Error != nil || reply != 7 {
+		t.Fatalf("in-flight call = %d, %v; want 7", reply, inflight.Error)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("Shutdown = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return once the call finished")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	s := NewServer()
+	g := newGated()
+	c, _ := startGated(t, s, g)
+
+	var reply int
+	stuck := c.Go(context.Background(), "Gated.Wait", 1, &reply, nil)
+	<-g.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	// closing the connection gave up on the call
+	select {
+	case <-stuck.Done:
+		if stuck.Error == nil {
+			t.Fatal("the stuck call succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the stuck call outlived Shutdown")
+	}
+}
+
+func TestShutdownHandshaking(t *testing.T) {
+	s := NewServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	// a peer that stalls halfway through its handshake line
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Write([]byte("RPC g")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the server pick it up
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	raw.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := raw.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read after Shutdown = %v, want the connection closed", err)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	s := NewServer()
+	g := newGated()
+	c, _ := startGated(t, s, g, MaxConcurrent("Wait", 2))
+
+	var a, b int
+	calls := []*client.Call{
+		c.Go(context.Background(), "Gated.Wait", 1, &a, nil),
+		c.Go(context.Background(), "Gated.Wait", 2, &b, nil),
+	}
+	<-g.started
+	<-g.started
+
+	var n int
+	if err := c.Call(context.Background(), "Gated.Wait", 3, &n); !errors.Is(err, protocol.ErrOverloaded) {
+		t.Fatalf("call over the cap = %v, want ErrOverloaded", err)
+	}
+	// the cap is per method
+	if err := c.Call(context.Background(), "Gated.Now", 4, &n); err != nil || n != 4 {
+		t.Fatalf("Now = %d, %v; want 4", n, err)
+	}
+
+	close(g.release)
+	for _, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	// finished calls give their slots back
+	if err := c.Call(context.Background(), "Gated.Wait", 5, &n); err != nil || n != 5 {
+		t.Fatalf("Wait after release = %d, %v; want 5", n, err)
+	}
+}
+
+func TestMaxConcurrentOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  RegisterOption
+		err  string
+	}{
+		{"unknown method", MaxConcurrent("Nope", 1), "no method Nope to limit"},
+		{"zero", MaxConcurrent("Wait", 0), "must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewServer().Register("Gated", newGated(), tt.opt)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Register = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
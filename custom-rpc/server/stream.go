@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/shahin-bayat/custom-rpc/internal/flow"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+var errStreamDone = errors.New("stream is closed")
+
+// streamBinder is implemented by every *Stream[In, Out]. It lets the
+// connection wire up a handler's stream without knowing In and Out.
+type streamBinder interface {
+	bind(ServerStream)
+	newIn() any
+	messageTypes() (in, out reflect.Type)
+}
+
+// stream is the untyped state of one open stream on a connection.
+type stream struct {
+	conn   *conn
+	method string
+	seq    uint64
+	ctx    context.Context
+	abort  context.CancelCauseFunc
+	send   *flow.SendWindow
+	recv   *flow.RecvQueue
+	newIn  func() any // fresh *In to decode a client message into
+}
+
+func (st *stream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *stream) SendMsg(msg any) error {
+	if err := st.send.Acquire(st.ctx); err != nil {
+		return err
+	}
+	return st.conn.write(&protocol.Response{
+		Kind:          protocol.KindStreamMsg,
+		ServiceMethod: st.method,
+		Seq:           st.seq,
+	}, msg)
+}
+
+func (st *stream) RecvMsg() (any, error) {
+	return st.recv.Pop(st.ctx)
+}
+
+func (st *stream) grant(n int) {
+	err := st.conn.write(&protocol.Response{
+		Kind:          protocol.KindWindowUpdate,
+		ServiceMethod: st.method,
+		Seq:           st.seq,
+		Window:        uint32(n),
+	}, invalidBody)
+	if err != nil {
+		st.abort(err)
+	}
+}
+
+// Stream is a handler's end of a streaming call. It receives In messages
+// from the client and sends Out messages back, in either order and any
+// number of times:
+//
+//	func (t *T) Watch(ctx context.Context, args *Filter, stream *server.Stream[struct{}, Event]) error // server stream
+//	func (t *T) Upload(ctx context.Context, stream *server.Stream[Chunk, struct{}]) error             // client stream
+//	func (t *T) Chat(ctx context.Context, stream *server.Stream[Message, Message]) error              // bidirectional
+//
+// Use struct{} for a direction that carries no messages. The stream ends
+// when the handler returns; its error, if any, is what the client's Recv
+// reports after the last message.
+type Stream[In, Out any] struct {
+	ss ServerStream
+}
+
+func (s *Stream[In, Out]) Context() context.Context {
+	return s.ss.Context()
+}
+
+// Send queues msg for the client, waiting while the client has no room
+// for more messages.
+func (s *Stream[In, Out]) Send(msg *Out) error {
+	return s.ss.SendMsg(msg)
+}
+
+// Recv returns the next message from the client, or io.EOF once the
+// client has closed its sending side.
+func (s *Stream[In, Out]) Recv() (*In, error) {
+	msg, err := s.ss.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+	in, ok := msg.(*In)
+	if !ok {
+		return nil, fmt.Errorf("stream message is %T, want %T", msg, in)
+	}
+	return in, nil
+}
+
+func (s *Stream[In, Out]) bind(ss ServerStream) {
+	s.ss = ss
+}
+
+func (s *Stream[In, Out]) newIn() any {
+	return new(In)
+}
+
+func (s *Stream[In, Out]) messageTypes() (in, out reflect.Type) {
+	return reflect.TypeFor[In](), reflect.TypeFor[Out]()
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shahin-bayat/custom-rpc/client"
+	"github.com/shahin-bayat/custom-rpc/protocol"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and client
+// certificates for two teams.
+type testPKI struct {
+	pool     *x509.CertPool
+	server   tls.Certificate
+	ops, dev tls.Certificate // CN alice in OU ops, CN bob in OU dev
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	now := time.Now()
+	template := func(serial int64, subject pkix.Name) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      subject,
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+		}
+	}
+
+	caTemplate := template(1, pkix.Name{CommonName: "test ca"})
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	caCert, caKey := issue(t, caTemplate, nil, nil)
+
+	serverTemplate := template(2, pkix.Name{CommonName: "server"})
+	serverTemplate.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	clientTemplate := func(serial int64, cn, ou string) *x509.Certificate {
+		tmpl := template(serial, pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}})
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		return tmpl
+	}
+
+	pki := &testPKI{pool: x509.NewCertPool()}
+	pki.pool.AddCert(caCert.Leaf)
+	pki.server, _ = issue(t, serverTemplate, caCert.Leaf, caKey)
+	pki.ops, _ = issue(t, clientTemplate(3, "alice", "ops"), caCert.Leaf, caKey)
+	pki.dev, _ = issue(t, clientTemplate(4, "bob", "dev"), caCert.Leaf, caKey)
+	return pki
+}
+
+// issue signs template with parent, or self-signs it when parent is nil.
+func issue(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, key
+}
+
+type admin struct{}
+
+func (admin) Reset(ctx context.Context, args *int, reply *string) error {
+	p, _ := PeerFromContext(ctx)
+	*reply = p.Certificate.Subject.CommonName
+	return nil
+}
+
+func (admin) Tail(ctx context.Context, stream *Stream[struct{}, string]) error { return nil }
+
+type public struct{}
+
+func (public) Hello(ctx context.Context, args *int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	switch {
+	case !ok:
+		*reply = "no peer"
+	case p.Certificate != nil:
+		*reply = "hello " + p.Certificate.Subject.CommonName
+	case p.TLS != nil:
+		*reply = "hello tls"
+	default:
+		*reply = "hello plain"
+	}
+	return nil
+}
+
+func TestServeTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	s := NewServer(WithACL(Rule{Pattern: "Admin.*", Allow: RequireOU("ops")}))
+	if err := s.Register("Admin", admin{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("Public", public{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.ServeTLS(ln, &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		admin error // nil if Admin.Reset succeeds
+		hello string
+	}{
+		{"ops", []tls.Certificate{pki.ops}, nil, "hello alice"},
+		{"dev", []tls.Certificate{pki.dev}, protocol.ErrPermissionDenied, "hello bob"},
+		{"no certificate", nil, protocol.ErrUnauthenticated, "hello tls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := client.Dial("tcp", ln.Addr().String(), client.WithTLS(&tls.Config{
+				RootCAs:      pki.pool,
+				Certificates: tt.certs,
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			var reply string
+			err = c.Call(context.Background(), "Admin.Reset", 1, &reply)
+			if tt.admin == nil {
+				if err != nil || reply != "alice" {
+					t.Fatalf("Admin.Reset = %q, %v; want alice", reply, err)
+				}
+			} else if !errors.Is(err, tt.admin) {
+				t.Fatalf("Admin.Reset = %v, want %v", err, tt.admin)
+			}
+
+			// the ACL applies to streams as well
+			stream, err := client.OpenStream[struct{}, string](context.Background(), c, "Admin.Tail", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = stream.Recv()
+			if tt.admin == nil && !errors.Is(err, io.EOF) || tt.admin != nil && !errors.Is(err, tt.admin) {
+				t.Fatalf("Admin.Tail = %v, want %v", err, tt.admin)
+			}
+
+			if err := c.Call(context.Background(), "Public.Hello", 1, &reply); err != nil || reply != tt.hello {
+				t.Fatalf("Public.Hello = %q, %v; want %q", reply, err, tt.hello)
+			}
+		})
+	}
+
+	t.Run("plaintext client", func(t *testing.T) {
+		if c, err := client.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+			t.Fatal("a plaintext client got through the TLS listener")
+		}
+	})
+}
+
+func TestPeerWithoutTLS(t *testing.T) {
+	s := NewServer(WithACL(Rule{Pattern: "Admin.*", Allow: RequireCN("alice")}))
+	if err := s.Register("Admin", admin{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("Public", public{}); err != nil {
+		t.Fatal(err)
+	}
+	c := pipeClient(t, s)
+
+	var reply string
+	if err := c.Call(context.Background(), "Admin.Reset", 1, &reply); !errors.Is(err, protocol.ErrUnauthenticated) {
+		t.Fatalf("Admin.Reset = %v, want ErrUnauthenticated", err)
+	}
+	if err := c.Call(context.Background(), "Public.Hello", 1, &reply); err != nil || reply != "hello plain" {
+		t.Fatalf("Public.Hello = %q, %v; want %q", reply, err, "hello plain")
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern, method string
+		want            bool
+	}{
+		{"*", "Admin.Reset", true},
+		{"Admin.*", "Admin.Reset", true},
+		{"Admin.*", "AdminTools.Reset", false},
+		{"Admin.Reset", "Admin.Reset", true},
+		{"Admin.Reset", "Admin.ResetAll", false},
+	}
+	for _, tt := range tests {
+		if got := (Rule{Pattern: tt.pattern}).matches(tt.method); got != tt.want {
+			t.Errorf("Rule %q matches %q = %v, want %v", tt.pattern, tt.method, got, tt.want)
+		}
+	}
+}
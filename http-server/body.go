@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errBodyTooLarge = &httpError{status: 413, msg: "request body too large"}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// fixedLengthReader reads a body framed by Content-Length.
+type fixedLengthReader struct {
+	r io.Reader
+	n int64 // bytes left
+}
+
+func (f *fixedLengthReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= int64(n)
+	if errors.Is(err, io.EOF) && f.n > 0 {
+		err = io.ErrUnexpectedEOF // the client hung up mid-body
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body (RFC 9112, section 7.1), collecting
+// the trailer fields that may follow the last chunk.
+type chunkedReader struct {
+	br              *bufio.Reader
+	left            int64 // bytes left in the current chunk
+	started         bool  // the first chunk header has been read
+	err             error
+	trailer         Header
+	maxTrailerBytes int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.br.Read(p)
+	c.left -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk finishes the current chunk and reads the next chunk header. At
+// the last chunk it reads the trailer and returns io.EOF.
+func (c *chunkedReader) nextChunk() error {
+	budget := c.maxTrailerBytes
+	if c.started {
+		// every chunk's data is followed by CRLF
+		line, err := readLine(c.br, &budget)
+		if err != nil {
+			return chunkError(err)
+		}
+		if line != "" {
+			return badRequest("missing CRLF after chunk data")
+		}
+	}
+	c.started = true
+
+	line, err := readLine(c.br, &budget)
+	if err != nil {
+		return chunkError(err)
+	}
+	sizeField, _, _ := strings.Cut(line, ";") // chunk extensions are ignored
+	sizeField = strings.TrimRight(sizeField, " \t")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || sizeField == "" || sizeField[0] == '+' || sizeField[0] == '-' {
+		return badRequest("invalid chunk size %q", sizeField)
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+
+	budget = c.maxTrailerBytes
+	if err := readHeader(c.br, &budget, c.trailer); err != nil {
+		return chunkError(err)
+	}
+	return io.EOF
+}
+
+func chunkError(err error) error {
+	switch {
+	case errors.Is(err, io.EOF):
+		return io.ErrUnexpectedEOF
+	case errors.Is(err, errLineTooLong):
+		return badRequest("chunk header or trailer too long")
+	}
+	return err
+}
+
+// maxBytesReader fails with errBodyTooLarge once more than n bytes have
+// been read, for bodies whose length is not known up front.
+type maxBytesReader struct {
+	r io.Reader
+	n int64 // bytes still allowed
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1] // one byte more than allowed tells us the limit was crossed
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	if m.n < 0 {
+		return n + int(m.n), errBodyTooLarge
+	}
+	return n, err
+}
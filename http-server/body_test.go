@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		trailer map[string]string
+		status  int  // of the error, when the body is malformed
+		eof     bool // the body ends early
+	}{
+		{name: "empty", raw: "0\r\n\r\n"},
+		{name: "one chunk", raw: "5\r\nhello\r\n0\r\n\r\n", want: "hello"},
+		{name: "two chunks", raw: "5\r\nhello\r\n1\r\n!\r\n0\r\n\r\n", want: "hello!"},
+		{name: "upper-case hex", raw: "A\r\n0123456789\r\n0\r\n\r\n", want: "0123456789"},
+		{name: "leading zeros", raw: "005\r\nhello\r\n000\r\n\r\n", want: "hello"},
+		{name: "bare LF", raw: "5\nhello\n0\n\n", want: "hello"},
+		{name: "extension", raw: "5;name=value\r\nhello\r\n0;x\r\n\r\n", want: "hello"},
+		{name: "whitespace before extension", raw: "5 ;x\r\nhello\r\n0\r\n\r\n", want: "hello"},
+		{
+			name:    "trailer",
+			raw:     "5\r\nhello\r\n0\r\nChecksum: abc\r\nx-other: d\r\n\r\n",
+			want:    "hello",
+			trailer: map[string]string{"Checksum": "abc", "X-Other": "d"},
+		},
+
+		{name: "empty size", raw: "\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "non-hex size", raw: "5g\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "signed size", raw: "+5\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "negative size", raw: "-5\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "hex prefix", raw: "0x5\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "overflowing size", raw: "10000000000000000\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "data longer than size", raw: "3\r\nhello\r\n0\r\n\r\n", status: 400},
+		{name: "missing CRLF after data", raw: "5\r\nhello0\r\n\r\n", status: 400},
+		{name: "malformed trailer", raw: "0\r\nnot a field\r\n\r\n", status: 400},
+		{name: "trailer too long", raw: "0\r\nX: " + strings.Repeat("a", 300) + "\r\n\r\n", status: 400},
+		{name: "size line too long", raw: strings.Repeat("0", 300) + "5\r\nhello\r\n0\r\n\r\n", status: 400},
+
+		{name: "no data", raw: "", eof: true},
+		{name: "truncated data", raw: "5\r\nhel", eof: true},
+		{name: "no last chunk", raw: "5\r\nhello\r\n", eof: true},
+		{name: "no end of trailer", raw: "5\r\nhello\r\n0\r\n", eof: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trailer := make(Header)
+			cr := &chunkedReader{br: bufio.NewReader(strings.NewReader(tt.raw)), trailer: trailer, maxTrailerBytes: 256}
+			got, err := io.ReadAll(cr)
+			switch {
+			case tt.status != 0:
+				if s := errStatus(err); s != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+				return
+			case tt.eof:
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if len(trailer) != len(tt.trailer) {
+				t.Errorf("trailer = %v, want %v", trailer, tt.trailer)
+			}
+			for k, v := range tt.trailer {
+				if trailer.Get(k) != v {
+					t.Errorf("trailer %s = %q, want %q", k, trailer.Get(k), v)
+				}
+			}
+		})
+	}
+}
+
+// TestChunkedReaderLeavesNextRequest checks that the body ends exactly
+// after the last chunk, so a pipelined request behind it is intact.
+func TestChunkedReaderLeavesNextRequest(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("3\r\nabc\r\n0\r\n\r\nGET / HTTP/1.1\r\n"))
+	cr := &chunkedReader{br: br, trailer: make(Header), maxTrailerBytes: 256}
+	if _, err := io.ReadAll(cr); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(br)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("left behind %q", rest)
+	}
+}
+
+func TestFixedLengthReader(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		n    int64
+		want string
+		err  error
+	}{
+		{"exact", "hello", 5, "hello", nil},
+		{"stops at length", "hello world", 5, "hello", nil},
+		{"zero", "hello", 0, "", nil},
+		{"short", "hel", 5, "hel", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(&fixedLengthReader{r: strings.NewReader(tt.raw), n: tt.n})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		wantN    int
+		tooLarge bool
+	}{
+		{"under", "abc", 5, 3, false},
+		{"at limit", "abcde", 5, 5, false},
+		{"one over", "abcdef", 5, 5, true},
+		{"far over", strings.Repeat("a", 100), 5, 5, true},
+		{"zero limit", "a", 0, 0, true},
+		{"zero limit, empty body", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// one byte at a time, so the limit is crossed in the middle of a read too
+			got, err := io.ReadAll(&maxBytesReader{r: &oneByteReader{strings.NewReader(tt.body)}, n: tt.limit})
+			if tt.tooLarge != (err == errBodyTooLarge) {
+				t.Fatalf("err = %v, want too large: %v", err, tt.tooLarge)
+			}
+			if !tt.tooLarge && err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if len(got) != tt.wantN {
+				t.Errorf("read %d bytes, want %d", len(got), tt.wantN)
+			}
+
+			got, err = io.ReadAll(&maxBytesReader{r: strings.NewReader(tt.body), n: tt.limit})
+			if tt.tooLarge != (err == errBodyTooLarge) || len(got) != tt.wantN {
+				t.Errorf("in one read: %d bytes, err = %v", len(got), err)
+			}
+		})
+	}
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest body worth compressing, when its length
+// is known up front.
+const minCompressSize = 256
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+var zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(nil) }}
+
+// Compress wraps h to compress text-like responses with gzip or deflate,
+// whichever the client prefers in Accept-Encoding. Responses that already
+// have a Content-Encoding, such as precompressed files, and partial
+// content are left alone.
+func Compress(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r), head: r.Method == "HEAD"}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+type compressWriter struct {
+	ResponseWriter
+	encoding string // negotiated with the client; "" if none
+	head     bool   // no body is sent, so there is nothing to compress
+	decided  bool   // the header has gone to the underlying writer
+	enc      io.WriteCloser
+	release  func()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.decided {
+		cw.decided = true
+		cw.start(status)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(200)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Hijack passes through to the underlying writer, so protocol upgrades
+// work behind Compress.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(Hijacker)
+	if !ok || cw.decided {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	return hj.Hijack()
+}
+
+// start decides whether to compress a response with status and, if so,
+// rewrites its header to match.
+func (cw *compressWriter) start(status int) {
+	h := cw.Header()
+	if !compressible(h.Get("Content-Type")) {
+		return
+	}
+	addVary(h, "Accept-Encoding") // caches must keep the encodings apart
+	if cw.encoding == "" || (status != 200 && status != 304) || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n < minCompressSize {
+		return
+	}
+
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		// the bytes differ from the uncompressed representation's
+		h.Set("ETag", "W/"+etag)
+	}
+	if status == 304 {
+		// no body follows, but the validators must match the 200's
+		return
+	}
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length") // unknown until the body is compressed
+	h.Del("Accept-Ranges")  // ranges would refer to the compressed bytes
+	if cw.head {
+		return
+	}
+	switch cw.encoding {
+	case "gzip":
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(cw.ResponseWriter)
+		cw.enc, cw.release = zw, func() { gzipWriters.Put(zw) }
+	case "deflate":
+		zw := zlibWriters.Get().(*zlib.Writer)
+		zw.Reset(cw.ResponseWriter)
+		cw.enc, cw.release = zw, func() { zlibWriters.Put(zw) }
+	}
+}
+
+// close flushes the compressed stream once the handler is done.
+func (cw *compressWriter) close() {
+	if cw.enc == nil {
+		return
+	}
+	if err := cw.enc.Close(); err != nil {
+		slog.Error("failed to finish compressed response", "error", err)
+	}
+	cw.release()
+	cw.enc = nil
+}
+
+func addVary(h Header, field string) {
+	if !slices.Contains(h.Values("Vary"), field) {
+		h.Add("Vary", field)
+	}
+}
+
+// compressible reports whether contentType is text-like enough to shrink.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from the request's
+// Accept-Encoding by quality, preferring gzip on a tie. It returns "" if
+// the client accepts neither.
+func negotiateEncoding(r *Request) string {
+	return pickEncoding(r.Header.Values("Accept-Encoding"), "gzip", "deflate")
+}
+
+// pickEncoding returns the one of offers, in order of preference, that
+// accept rates highest (RFC 9110, section 12.5.3), or "" if none is
+// acceptable.
+func pickEncoding(accept []string, offers ...string) string {
+	qualities := map[string]float64{}
+	wildcard := -1.0
+	for _, elem := range splitList(accept) {
+		coding, params, _ := strings.Cut(elem, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, ok := qualities[offer]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("compress me ", 100)
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		header         map[string]string // set by the handler
+		status         int
+		body           string
+		encoding       string // the expected Content-Encoding
+		vary           bool
+	}{
+		{"gzip", "GET", "gzip, deflate", map[string]string{"Content-Type": "text/html"}, 200, large, "gzip", true},
+		{"deflate preferred", "GET", "gzip;q=0.5, deflate", map[string]string{"Content-Type": "application/json"}, 200, large, "deflate", true},
+		{"wildcard", "GET", "*", map[string]string{"Content-Type": "image/svg+xml"}, 200, large, "gzip", true},
+		{"not accepted", "GET", "br", map[string]string{"Content-Type": "text/plain"}, 200, large, "", true},
+		{"refused", "GET", "gzip;q=0, deflate;q=0", map[string]string{"Content-Type": "text/plain"}, 200, large, "", true},
+		{"no Accept-Encoding", "GET", "", map[string]string{"Content-Type": "text/plain"}, 200, large, "", true},
+		{"small", "GET", "gzip", map[string]string{"Content-Type": "text/plain", "Content-Length": "5"}, 200, "small", "", true},
+		{"binary", "GET", "gzip", map[string]string{"Content-Type": "image/png"}, 200, large, "", false},
+		{"already encoded", "GET", "gzip", map[string]string{"Content-Type": "text/plain", "Content-Encoding": "br"}, 200, large, "br", true},
+		{"partial content", "GET", "gzip", map[string]string{"Content-Type": "text/plain", "Content-Range": "bytes 0-9/100"}, 206, large, "", true},
+		{"error status", "GET", "gzip", map[string]string{"Content-Type": "text/plain"}, 404, large, "", true},
+		{"HEAD", "HEAD", "gzip", map[string]string{"Content-Type": "text/plain"}, 200, "", "gzip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(HandlerFunc(func(w ResponseWriter, r *Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			r := &Request{Method: tt.method, Path: "/", Header: make(Header)}
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := newRecorder()
+			h.ServeHTTP(w, r)
+
+			if got := w.header.Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := w.header.Get("Vary") == "Accept-Encoding"; got != tt.vary {
+				t.Errorf("Vary = %q, want Accept-Encoding: %v", w.header.Get("Vary"), tt.vary)
+			}
+			compressed := tt.encoding == "gzip" || tt.encoding == "deflate"
+			if compressed {
+				if w.header.Get("ETag") != `W/"abc"` || w.header.Get("Accept-Ranges") != "" || w.header.Get("Content-Length") != "" {
+					t.Errorf("header = %v, want a weak ETag and no Accept-Ranges or Content-Length", w.header)
+				}
+			} else if w.header.Get("ETag") != `"abc"` {
+				t.Errorf("ETag = %q, want it unchanged", w.header.Get("ETag"))
+			}
+			if got := decode(t, tt.encoding, w.body.String()); got != tt.body {
+				t.Errorf("body = %.40q, want %.40q", got, tt.body)
+			}
+		})
+	}
+}
+
+// TestCompressNotModified checks that a 304 carries the Vary and ETag the
+// 200 would have, so a cache revalidating a compressed copy keeps it.
+func TestCompressNotModified(t *testing.T) {
+	root := t.TempDir()
+	for name, size := range map[string]int{"big.txt": 2 * minCompressSize, "small.txt": 10} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(strings.Repeat("a", size)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer(t)
+	handler, err := routes(&Config{Hosts: []HostConfig{{Default: true, Root: root}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.handler = handler
+
+	tests := []struct {
+		path, acceptEncoding string
+		weak                 bool
+	}{
+		{"/big.txt", "gzip", true},
+		{"/big.txt", "", false},
+		{"/small.txt", "gzip", false},
+	}
+	for _, tt := range tests {
+		ae := ""
+		if tt.acceptEncoding != "" {
+			ae = "Accept-Encoding: " + tt.acceptEncoding + "\r\n"
+		}
+		ok := parseResponses(t, exchange(t, s, "GET "+tt.path+" HTTP/1.1\r\nHost: a\r\nConnection: close\r\n"+ae+"\r\n"))[0]
+		etag := ok.header.Get("ETag")
+		if ok.status != 200 || strings.HasPrefix(etag, "W/") != tt.weak {
+			t.Fatalf("%s with %q: %d with ETag %q, want 200 with a weak one: %v", tt.path, tt.acceptEncoding, ok.status, etag, tt.weak)
+		}
+
+		raw := exchange(t, s, "GET "+tt.path+" HTTP/1.1\r\nHost: a\r\nConnection: close\r\nIf-None-Match: "+etag+"\r\n"+ae+"\r\n")
+		resp := parseResponses(t, raw)[0]
+		if resp.status != 304 {
+			t.Fatalf("%s with %q: revalidation = %d, want 304", tt.path, tt.acceptEncoding, resp.status)
+		}
+		if resp.header.Get("ETag") != etag || resp.header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s with %q: 304 has ETag %q and Vary %q, want %q and Accept-Encoding",
+				tt.path, tt.acceptEncoding, resp.header.Get("ETag"), resp.header.Get("Vary"), etag)
+		}
+		for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			if v := resp.header.Get(key); v != "" {
+				t.Errorf("%s with %q: 304 carries %s %q", tt.path, tt.acceptEncoding, key, v)
+			}
+		}
+	}
+}
+
+// decode undoes the Content-Encoding of body.
+func decode(t *testing.T, encoding, body string) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch {
+	case body == "":
+		return ""
+	case encoding == "gzip":
+		r, err = gzip.NewReader(strings.NewReader(body))
+	case encoding == "deflate":
+		r, err = zlib.NewReader(strings.NewReader(body))
+	default:
+		return body
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPickEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip; q=0.8, deflate; q=0.9", "deflate"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"gzip;q=bad", ""},
+	}
+	for _, tt := range tests {
+		if got := pickEncoding([]string{tt.accept}, "gzip", "deflate"); got != tt.want {
+			t.Errorf("pickEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	root := t.TempDir()
+	var gz strings.Builder
+	zw := gzip.NewWriter(&gz)
+	io.WriteString(zw, "console.log(1)")
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "app.js.gz"), []byte(gz.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := NewFileServer(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Compress(files)
+
+	for _, accept := range []string{"gzip", ""} {
+		r := &Request{Method: "GET", Path: "/app.js", Header: make(Header)}
+		if accept != "" {
+			r.Header.Set("Accept-Encoding", accept)
+		}
+		w := newRecorder()
+		h.ServeHTTP(w, r)
+
+		encoding := w.header.Get("Content-Encoding")
+		if encoding != accept || !strings.Contains(w.header.Get("Content-Type"), "javascript") {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, Content-Type %q", accept, encoding, w.header.Get("Content-Type"))
+		}
+		if w.header.Get("Content-Length") != strconv.Itoa(w.body.Len()) {
+			t.Errorf("Accept-Encoding %q: Content-Length %s for a %d-byte body", accept, w.header.Get("Content-Length"), w.body.Len())
+		}
+		if got := decode(t, encoding, w.body.String()); got != "console.log(1)" {
+			t.Errorf("Accept-Encoding %q: body = %q", accept, got)
+		}
+		if vary := w.header.Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q, want it once", accept, vary)
+		}
+	}
+}
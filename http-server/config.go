@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config is the server's configuration, read from a JSON file such as
+//
+//	{
+//	  "listen": ":8080",
+//	  "hosts": [
+//	    {"names": ["docs.internal"], "root": "sites/docs", "listings": true},
+//	    {"names": ["status.internal"], "root": "sites/status", "health": "/healthz"},
+//	    {"default": true, "root": "www"}
+//	  ],
+//	  "tls": {
+//	    "listen": ":8443",
+//	    "certificates": [{"cert": "docs.pem", "key": "docs-key.pem"}],
+//	    "redirectHTTP": true,
+//	    "hstsMaxAge": "8760h"
+//	  }
+//	}
+//
+// Relative roots and certificate files are taken relative to the file's
+// directory. Limits left out get the defaults set by LoadConfig.
+type Config struct {
+	Listen         string `json:"listen"`
+	MaxHeaderBytes int    `json:"maxHeaderBytes"`
+	MaxBodyBytes   int64  `json:"maxBodyBytes"`
+	MaxRequests    int    `json:"maxRequests"` // per connection
+
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"` // for the request line and header fields
+	ReadBodyTimeout   Duration `json:"readBodyTimeout"`   // for the whole body
+	WriteTimeout      Duration `json:"writeTimeout"`      // for each write of a response
+	IdleTimeout       Duration `json:"idleTimeout"`       // between requests on a connection
+
+	// 0 means no limit. Connections over them are answered with 503 and
+	// 429 respectively.
+	MaxConnections      int `json:"maxConnections"`
+	MaxConnectionsPerIP int `json:"maxConnectionsPerIP"`
+
+	Hosts []HostConfig `json:"hosts"`
+	TLS   *TLSConfig   `json:"tls"`
+}
+
+// HostConfig describes one site. Names are matched against the Host
+// header without its port; a name like "*.example.com" matches any
+// subdomain. The default host answers requests no other host claims.
+// Health, if set, is the path of a JSON health check served in place of
+// any file there.
+type HostConfig struct {
+	Names    []string `json:"names"`
+	Default  bool     `json:"default"`
+	Root     string   `json:"root"`
+	Listings bool     `json:"listings"`
+	Health   string   `json:"health"`
+}
+
+// Duration is a time.Duration written as a string, such as "90s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadConfig reads and checks the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	cfg := &Config{
+		MaxHeaderBytes:      1 << 20,
+		MaxBodyBytes:        10 << 20,
+		MaxRequests:         100,
+		ReadHeaderTimeout:   Duration(10 * time.Second),
+		ReadBodyTimeout:     Duration(60 * time.Second),
+		WriteTimeout:        Duration(30 * time.Second),
+		IdleTimeout:         Duration(60 * time.Second),
+		MaxConnections:      1000,
+		MaxConnectionsPerIP: 100,
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := cfg.validate(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the configuration and resolves relative roots against
+// dir.
+func (cfg *Config) validate(dir string) error {
+	if cfg.Listen == "" {
+		return errors.New("listen address is required")
+	}
+	if cfg.MaxHeaderBytes <= 0 || cfg.MaxBodyBytes < 0 || cfg.MaxRequests <= 0 {
+		return errors.New("limits must be positive")
+	}
+	if cfg.ReadHeaderTimeout <= 0 || cfg.ReadBodyTimeout <= 0 || cfg.WriteTimeout <= 0 || cfg.IdleTimeout <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	if cfg.MaxConnections < 0 || cfg.MaxConnectionsPerIP < 0 {
+		return errors.New("connection limits cannot be negative")
+	}
+	if len(cfg.Hosts) == 0 {
+		return errors.New("at least one host is required")
+	}
+
+	seen := make(map[string]bool)
+	defaults := 0
+	for i := range cfg.Hosts {
+		h := &cfg.Hosts[i]
+		if h.Default {
+			defaults++
+		} else if len(h.Names) == 0 {
+			return fmt.Errorf("host %d has no names and is not the default", i)
+		}
+		for j, name := range h.Names {
+			name = normalizeHost(name)
+			if name == "" {
+				return fmt.Errorf("host %d has an empty name", i)
+			}
+			if seen[name] {
+				return fmt.Errorf("host name %q is configured twice", name)
+			}
+			seen[name] = true
+			h.Names[j] = name
+		}
+		if h.Root == "" {
+			return fmt.Errorf("host %d has no root", i)
+		}
+		h.Root = resolvePath(dir, h.Root)
+		if h.Health != "" && (!strings.HasPrefix(h.Health, "/") || strings.ContainsAny(h.Health, "{}")) {
+			return fmt.Errorf("host %d has an invalid health path %q", i, h.Health)
+		}
+	}
+	if defaults > 1 {
+		return errors.New("only one host can be the default")
+	}
+
+	if cfg.TLS != nil {
+		if err := cfg.TLS.validate(); err != nil {
+			return err
+		}
+		for i := range cfg.TLS.Certificates {
+			cc := &cfg.TLS.Certificates[i]
+			cc.Cert, cc.Key = resolvePath(dir, cc.Cert), resolvePath(dir, cc.Key)
+		}
+	}
+	return nil
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// normalizeHost lower-cases a host name and drops a trailing dot, so
+// "Example.COM." and "example.com" are the same host.
+func normalizeHost(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		err  string // a substring of the error; "" if the config is valid
+	}{
+		{"minimal", `{"listen": ":80", "hosts": [{"default": true, "root": "www"}]}`, ""},
+		{"no listen", `{"hosts": [{"default": true, "root": "www"}]}`, "listen address is required"},
+		{"no hosts", `{"listen": ":80"}`, "at least one host"},
+		{"nameless host", `{"listen": ":80", "hosts": [{"root": "www"}]}`, "has no names"},
+		{"empty name", `{"listen": ":80", "hosts": [{"names": [" "], "root": "www"}]}`, "empty name"},
+		{"duplicate name", `{"listen": ":80", "hosts": [
+			{"names": ["a.test"], "root": "a"},
+			{"names": ["A.test."], "root": "b"}]}`, `"a.test" is configured twice`},
+		{"no root", `{"listen": ":80", "hosts": [{"default": true}]}`, "has no root"},
+		{"two defaults", `{"listen": ":80", "hosts": [
+			{"default": true, "root": "a"},
+			{"default": true, "root": "b"}]}`, "only one host"},
+		{"relative health path", `{"listen": ":80", "hosts": [{"default": true, "root": "www", "health": "healthz"}]}`, "invalid health path"},
+		{"health path with a parameter", `{"listen": ":80", "hosts": [{"default": true, "root": "www", "health": "/{x}"}]}`, "invalid health path"},
+		{"negative limit", `{"listen": ":80", "maxRequests": -1, "hosts": [{"default": true, "root": "www"}]}`, "must be positive"},
+		{"zero timeout", `{"listen": ":80", "writeTimeout": "0s", "hosts": [{"default": true, "root": "www"}]}`, "timeouts must be positive"},
+		{"negative connection limit", `{"listen": ":80", "maxConnectionsPerIP": -1, "hosts": [{"default": true, "root": "www"}]}`, "cannot be negative"},
+		{"unlimited connections", `{"listen": ":80", "maxConnections": 0, "maxConnectionsPerIP": 0, "hosts": [{"default": true, "root": "www"}]}`, ""},
+		{"bad duration", `{"listen": ":80", "idleTimeout": "soon", "hosts": [{"default": true, "root": "www"}]}`, "invalid duration"},
+		{"numeric duration", `{"listen": ":80", "idleTimeout": 30, "hosts": [{"default": true, "root": "www"}]}`, "must be a string"},
+		{"not JSON", `listen: 80`, "failed to parse"},
+		{"tls", `{"listen": ":80", "hosts": [{"default": true, "root": "www"}],
+			"tls": {"listen": ":443", "certificates": [{"cert": "c.pem", "key": "k.pem"}], "hstsMaxAge": "24h"}}`, ""},
+		{"tls without listen", `{"listen": ":80", "hosts": [{"default": true, "root": "www"}],
+			"tls": {"certificates": [{"cert": "c.pem", "key": "k.pem"}]}}`, "tls listen address is required"},
+		{"tls without certificates", `{"listen": ":80", "hosts": [{"default": true, "root": "www"}],
+			"tls": {"listen": ":443"}}`, "at least one certificate"},
+		{"negative hstsMaxAge", `{"listen": ":80", "hosts": [{"default": true, "root": "www"}],
+			"tls": {"listen": ":443", "certificates": [{"cert": "c.pem", "key": "k.pem"}], "hstsMaxAge": "-1s"}}`, "cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("LoadConfig = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigResolves(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	data := `{
+		"listen": ":8080",
+		"idleTimeout": "90s",
+		"hosts": [
+			{"names": ["Docs.Example.com."], "root": "sites/docs", "listings": true},
+			{"default": true, "root": "/srv/www"}
+		],
+		"tls": {"listen": ":8443", "certificates": [{"cert": "certs/a.pem", "key": "/etc/a-key.pem"}]}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(cfg.IdleTimeout) != 90*time.Second {
+		t.Errorf("IdleTimeout = %v, want 90s", time.Duration(cfg.IdleTimeout))
+	}
+	// limits left out get their defaults
+	if cfg.MaxHeaderBytes != 1<<20 || cfg.MaxBodyBytes != 10<<20 || cfg.MaxRequests != 100 {
+		t.Errorf("limits = %d, %d, %d; want the defaults", cfg.MaxHeaderBytes, cfg.MaxBodyBytes, cfg.MaxRequests)
+	}
+	if cfg.ReadHeaderTimeout <= 0 || cfg.ReadBodyTimeout <= 0 || cfg.WriteTimeout <= 0 || cfg.MaxConnections != 1000 || cfg.MaxConnectionsPerIP != 100 {
+		t.Errorf("timeouts and connection limits = %+v, want the defaults", cfg)
+	}
+	docs := cfg.Hosts[0]
+	if docs.Names[0] != "docs.example.com" || docs.Root != filepath.Join(dir, "sites/docs") || !docs.Listings {
+		t.Errorf("docs host = %+v", docs)
+	}
+	if cc := cfg.TLS.Certificates[0]; cc.Cert != filepath.Join(dir, "certs/a.pem") || cc.Key != "/etc/a-key.pem" {
+		t.Errorf("certificate = %+v, want the relative path resolved", cc)
+	}
+	if cfg.Hosts[1].Root != "/srv/www" {
+		t.Errorf("absolute root = %q, want it unchanged", cfg.Hosts[1].Root)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+		t.Fatal("LoadConfig succeeded for a missing file")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// Handler responds to one request.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// ResponseWriter is how a Handler builds its response. Header fields must
+// be set before the first call to WriteHeader or Write; Write sends a 200
+// status if WriteHeader was not called.
+type ResponseWriter interface {
+	Header() Header
+	WriteHeader(status int)
+	Write(p []byte) (int, error)
+}
+
+// Error replies with a plain-text error message.
+func Error(w ResponseWriter, status int, msg string) {
+	body := fmt.Sprintf("%d %s: %s\n", status, statusText[status], msg)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// writeJSON replies with v encoded as JSON.
+func writeJSON(w ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("failed to encode JSON response", "error", err)
+		Error(w, 500, "failed to encode response")
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// rejectTimeout bounds writing the response that turns away a connection
+// over the limits, which happens on the accept loop.
+const rejectTimeout = time.Second
+
+// connLimiter caps open connections, in total and per client IP. A limit
+// of 0 means no limit.
+type connLimiter struct {
+	max, perIP int
+
+	mu    sync.Mutex
+	total int
+	byIP  map[string]int
+}
+
+func newConnLimiter(max, perIP int) *connLimiter {
+	return &connLimiter{max: max, perIP: perIP, byIP: make(map[string]int)}
+}
+
+// acquire takes a slot for a connection from addr. If there is none it
+// returns the status to answer with: 503 when the server is full, 429
+// when the client has too many connections of its own.
+func (l *connLimiter) acquire(addr net.Addr) (int, bool) {
+	ip := clientIP(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.max > 0 && l.total >= l.max:
+		return 503, false
+	case l.perIP > 0 && l.byIP[ip] >= l.perIP:
+		return 429, false
+	}
+	l.total++
+	l.byIP[ip]++
+	return 0, true
+}
+
+func (l *connLimiter) release(addr net.Addr) {
+	ip := clientIP(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+func clientIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// reject answers a connection over the limits with status and closes it.
+// TLS connections are closed without an answer, as that would take a
+// handshake.
+func reject(c net.Conn, status int) {
+	defer c.Close()
+	if _, ok := c.(*tls.Conn); ok {
+		return
+	}
+	body := fmt.Sprintf("%d %s\n", status, statusText[status])
+	c.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nRetry-After: 1\r\n\r\n%s",
+		status, statusText[status], len(body), body)
+}
+
+// timeoutConn pushes the write deadline out before every write, so a
+// client that stops reading is dropped after timeout while a long
+// download that keeps making progress is not.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
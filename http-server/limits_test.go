@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(3, 2)
+	a1, a2, b := fakeAddr("10.0.0.1:1000"), fakeAddr("10.0.0.1:1001"), fakeAddr("10.0.0.2:1000")
+	steps := []struct {
+		addr   net.Addr
+		status int // 0 if the connection is let in
+	}{
+		{a1, 0},
+		{a2, 0},
+		{a1, 429}, // a third from the same IP
+		{b, 0},
+		{b, 503}, // the server is full
+	}
+	for i, st := range steps {
+		status, ok := l.acquire(st.addr)
+		if ok != (st.status == 0) || status != st.status {
+			t.Fatalf("step %d: acquire(%s) = %d, %v; want %d", i, st.addr, status, ok, st.status)
+		}
+	}
+	l.release(a1)
+	if status, ok := l.acquire(a2); !ok {
+		t.Fatalf("acquire after release = %d, want a slot", status)
+	}
+	l.release(a1)
+	l.release(a2)
+	l.release(b)
+	if l.total != 0 || len(l.byIP) != 0 {
+		t.Fatalf("after releasing everything: total %d, by IP %v", l.total, l.byIP)
+	}
+
+	unlimited := newConnLimiter(0, 0)
+	for range 100 {
+		if _, ok := unlimited.acquire(a1); !ok {
+			t.Fatal("a limiter without limits turned a connection away")
+		}
+	}
+}
+
+func TestAcceptLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		max, perIP int
+		status     string
+	}{
+		{"server full", 1, 0, "503 Service Unavailable"},
+		{"too many from one IP", 0, 1, "429 Too Many Requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.limiter = newConnLimiter(tt.max, tt.perIP)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go s.accept(ln, s.handler)
+
+			held, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a response shows the connection has been accepted and counted
+			get(t, held, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "200 OK")
+
+			rejected, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rejected.Close()
+			resp := readAll(t, rejected)
+			if !strings.HasPrefix(resp, "HTTP/1.1 "+tt.status+"\r\n") || !strings.Contains(resp, "\r\nRetry-After: 1\r\n") {
+				t.Fatalf("response = %q, want %s with Retry-After", resp, tt.status)
+			}
+
+			// closing the first connection frees its slot
+			held.Close()
+			deadline := time.Now().Add(time.Second)
+			for {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.SetDeadline(time.Now().Add(time.Second))
+				io.WriteString(c, "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+				// a retry that is still turned away may be reset, as its
+				// request goes unread
+				resp, _ := io.ReadAll(c)
+				c.Close()
+				if strings.HasPrefix(string(resp), "HTTP/1.1 200 ") {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("still turned away after the first connection closed: %q", resp)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// get sends a request on c and checks the status of the response, leaving
+// the connection open.
+func get(t *testing.T, c net.Conn, raw, status string) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(c, raw); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "HTTP/1.1 "+status+"\r\n" {
+		t.Fatalf("status line = %q, %v; want %s", line, err, status)
+	}
+}
+
+func readAll(t *testing.T, c net.Conn) string {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTimeouts(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("POST", "/upload", func(w ResponseWriter, r *Request) {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			Error(w, 400, "failed to read body")
+		}
+	})
+	router.HandleFunc("GET", "/download", func(w ResponseWriter, r *Request) {
+		w.Write([]byte(strings.Repeat("a", 1<<20)))
+	})
+
+	tests := []struct {
+		name string
+		raw  string
+		read bool   // whether the client reads what comes back
+		want string // the start of what the client reads, if it does
+	}{
+		{"trickled header", "GET / HTTP/1.1\r\nHost: a\r\n", true, "HTTP/1.1 408 Request Timeout\r\n"},
+		{"nothing sent", "", true, ""},
+		{"trickled body", "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nabc", true, "HTTP/1.1 400 Bad Request\r\n"},
+		{"client stops reading", "GET /download HTTP/1.1\r\nHost: a\r\n\r\n", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.readHeaderTimeout = 50 * time.Millisecond
+			s.readBodyTimeout = 50 * time.Millisecond
+			s.writeTimeout = 50 * time.Millisecond
+			client, conn := net.Pipe()
+			defer client.Close()
+			done := make(chan struct{})
+			go func() {
+				s.handleConnection(conn, router)
+				close(done)
+			}()
+
+			if tt.raw != "" {
+				io.WriteString(client, tt.raw) // the server reads it all before timing out
+			}
+			if tt.read {
+				resp := readAll(t, client)
+				if !strings.HasPrefix(resp, tt.want) {
+					t.Fatalf("response = %.60q, want %q", resp, tt.want)
+				}
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("the connection was not closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dirEntry is one entry of a directory listing.
+type dirEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// URL is the entry's link relative to the listed directory.
+func (e dirEntry) URL() string {
+	u := (&url.URL{Path: e.Name}).String()
+	if e.Dir {
+		u += "/"
+	}
+	return u
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!doctype html>
+<html>
+  <head>
+    <meta charset="UTF-8" />
+    <title>Index of {{.Path}}</title>
+  </head>
+  <body>
+    <h1>Index of {{.Path}}</h1>
+    <table>
+      {{- if ne .Path "/"}}
+      <tr><td><a href="../">../</a></td><td></td><td></td></tr>
+      {{- end}}
+      {{- range .Entries}}
+      <tr>
+        <td><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></td>
+        <td>{{if not .Dir}}{{.Size}}{{end}}</td>
+        <td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td>
+      </tr>
+      {{- end}}
+    </table>
+  </body>
+</html>
+`))
+
+// serveListing replies with the entries of dir, as JSON if the client
+// accepts that and HTML otherwise. Directories come first.
+func serveListing(w ResponseWriter, r *Request, dir *os.File) {
+	infos, err := dir.ReadDir(-1)
+	if err != nil {
+		slog.Error("failed to read directory", "path", r.Path, "error", err)
+		Error(w, 500, "failed to read directory")
+		return
+	}
+	entries := make([]dirEntry, 0, len(infos))
+	for _, de := range infos {
+		info, err := de.Info()
+		if err != nil {
+			continue // removed since we read the directory
+		}
+		entries = append(entries, dirEntry{
+			Name:    de.Name(),
+			Dir:     de.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	slices.SortFunc(entries, func(a, b dirEntry) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if acceptsJSON(r) {
+		writeJSON(w, 200, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(200)
+	if r.Method == "HEAD" {
+		return
+	}
+	err = listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []dirEntry
+	}{r.Path, entries})
+	if err != nil {
+		slog.Error("failed to write directory listing", "path", r.Path, "error", err)
+	}
+}
+
+// acceptsJSON reports whether the client lists application/json in Accept
+// ahead of text/html.
+func acceptsJSON(r *Request) bool {
+	for _, accept := range splitList(r.Header.Values("Accept")) {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
@@ -1,126 +1,313 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
-	"mime"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Server struct {
-	host     string
-	port     int
-	listener net.Listener
-}
+	addr              string
+	maxHeaderBytes    int
+	maxBodyBytes      int64
+	maxRequests       int
+	readHeaderTimeout time.Duration
+	readBodyTimeout   time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	limiter           *connLimiter
+	handler           Handler
 
-type Config struct {
-	host string
-	port int
+	// with tlsConfig set, handler is served over HTTPS on tlsAddr as well;
+	// redirectHTTP makes plain HTTP on addr only redirect there
+	tlsAddr      string
+	tlsConfig    *tls.Config
+	redirectHTTP bool
+	hsts         string
 }
 
 func (s *Server) Run() {
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		slog.Error("failed to start server", "error", err)
 		return
 	}
 	defer ln.Close()
-	s.listener = ln
-	s.accept()
+	if s.tlsConfig == nil {
+		s.accept(ln, s.handler)
+		return
+	}
+
+	tln, err := net.Listen("tcp", s.tlsAddr)
+	if err != nil {
+		slog.Error("failed to start tls listener", "error", err)
+		return
+	}
+	defer tln.Close()
+	plain, secure := s.handler, s.handler
+	if s.redirectHTTP {
+		plain = RedirectHTTPS(s.tlsAddr)
+	}
+	if s.hsts != "" {
+		secure = WithHSTS(secure, s.hsts)
+	}
+	go s.accept(ln, plain)
+	s.accept(tls.NewListener(tln, s.tlsConfig), secure)
 }
 
-func (s *Server) accept() {
+// accept serves the connections of ln with h. Connections over the
+// server's limits are turned away right here, so they never get a
+// goroutine of their own.
+func (s *Server) accept(ln net.Listener, h Handler) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				slog.Info("stopping listener; closed", "address", ln.Addr())
+				return
+			}
+			// most likely out of file descriptors; give some a chance to close
 			slog.Error("failed to accept", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if status, ok := s.limiter.acquire(conn.RemoteAddr()); !ok {
+			slog.Warn("rejecting connection over the limit", "remote address", conn.RemoteAddr(), "status", status)
+			reject(conn, status)
 			continue
 		}
 		slog.Info("connection accepted", "remote address", conn.RemoteAddr())
-		go s.handleConnection(conn)
+		go func() {
+			defer s.limiter.release(conn.RemoteAddr())
+			s.handleConnection(conn, h)
+		}()
 	}
 }
 
-func (s *Server) handleConnection(c net.Conn) {
+// handleConnection serves requests on c one after another until the client
+// asks to close, goes idle for too long, or reaches the per-connection
+// request cap. Pipelined requests are answered in the order they came in;
+// their responses are flushed together once no more requests are waiting.
+//
+// Every stage runs against a deadline, so a client that trickles in its
+// header or body, or stops reading the response, cannot hold on to the
+// connection.
+func (s *Server) handleConnection(c net.Conn, h Handler) {
 	defer c.Close()
-	buf := make([]byte, 1024)
-	n, err := c.Read(buf)
-	if err != nil && !errors.Is(err, io.EOF) {
-		slog.Error("failed to read message", "error", err)
-		c.Write([]byte("ERR: failed to read message\n"))
-		return
-	}
-	msg := string(buf[:n])
-	lines := strings.Split(msg, "\n")
-
-	httpReq := strings.TrimSpace(lines[0])
-	parts := strings.SplitN(httpReq, " ", 3)
-	if len(parts) < 3 {
-		c.Write([]byte("ERR: invalid request format\n"))
-		slog.Error("invalid request format", "line", httpReq)
-		return
-	}
-	method, path, protocol := parts[0], parts[1], parts[2]
-	slog.Info("received request", "method", method, "path", path, "protocol", protocol)
-	if method != "GET" {
-		c.Write([]byte("ERR: unsupported method\n"))
-		slog.Error("unsupported method", "method", method)
-		return
-	}
-	path = strings.TrimPrefix(path, "/")
-	if strings.Contains(path, "..") {
-		c.Write([]byte("ERR: invalid path\n"))
-		slog.Error("invalid path", "path", path)
-		return
-	}
-	if path == "" {
-		path = "index.html"
+	var state *tls.ConnectionState
+	if tc, ok := c.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+		err := tc.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("tls handshake failed", "remote address", c.RemoteAddr(), "error", err)
+			return
+		}
+		cs := tc.ConnectionState()
+		state = &cs
 	}
-	mimeType := mime.TypeByExtension(filepath.Ext(path))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	c = &timeoutConn{Conn: c, timeout: s.writeTimeout}
+	br := bufio.NewReader(c)
+	bw := bufio.NewWriter(c)
+	defer bw.Flush()
+
+	for served := 1; ; served++ {
+		// a new connection gets readHeaderTimeout for its first request from
+		// the start; later ones may idle before their header starts
+		if served == 1 {
+			c.SetReadDeadline(time.Now().Add(s.readHeaderTimeout))
+		} else {
+			c.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		if _, err := br.Peek(1); err != nil {
+			switch {
+			case isTimeout(err):
+				slog.Info("closing idle connection", "remote address", c.RemoteAddr())
+			case !errors.Is(err, io.EOF):
+				slog.Error("failed to read request", "remote address", c.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		if served > 1 {
+			c.SetReadDeadline(time.Now().Add(s.readHeaderTimeout))
+		}
+
+		req, err := readRequest(br, s.maxHeaderBytes, s.maxBodyBytes)
+		if err != nil {
+			var he *httpError
+			switch {
+			case errors.As(err, &he):
+				slog.Error("bad request", "remote address", c.RemoteAddr(), "error", err)
+			case isTimeout(err):
+				slog.Warn("timed out reading request header", "remote address", c.RemoteAddr())
+				he = &httpError{status: 408, msg: "timed out reading request header"}
+			case !errors.Is(err, io.EOF):
+				slog.Error("failed to read request", "remote address", c.RemoteAddr(), "error", err)
+			}
+			if he != nil {
+				w := newResponse(bw, nil, false)
+				Error(w, he.status, he.msg)
+				w.finish()
+			}
+			return
+		}
+		c.SetReadDeadline(time.Now().Add(s.readBodyTimeout))
+		req.RemoteAddr = c.RemoteAddr().String()
+		req.TLS = state
+		logRequest(req)
+
+		keepAlive := wantsKeepAlive(req) && served < s.maxRequests
+		w := newResponse(bw, req, keepAlive)
+		w.netConn, w.br = c, br
+		s.serve(w, req, h)
+		if w.hijacked {
+			return
+		}
+		if err := w.finish(); err != nil {
+			slog.Error("failed to write response", "remote address", c.RemoteAddr(), "error", err)
+			return
+		}
+		keepAlive = w.keepAlive
+
+		// whatever the handler left of the body has to go before the next request
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			slog.Error("failed to read request body", "remote address", c.RemoteAddr(), "error", err)
+			return
+		}
+		if !keepAlive {
+			return
+		}
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				slog.Error("failed to write response", "remote address", c.RemoteAddr(), "error", err)
+				return
+			}
+		}
 	}
-	header := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\n\r\n", mimeType)
-	file, err := os.Open(filepath.Join("www", path))
-	if err != nil {
-		slog.Error("failed to open file", "path", path, "error", err)
-		c.Write([]byte("HTTP/1.1 404 Not Found\r\n\r\nNot Found"))
-		return
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// wantsKeepAlive reports whether the client is willing to send another
+// request on the connection: by default for HTTP/1.1, only on request for
+// HTTP/1.0.
+func wantsKeepAlive(req *Request) bool {
+	for _, opt := range splitList(req.Header.Values("Connection")) {
+		switch strings.ToLower(opt) {
+		case "close":
+			return false
+		case "keep-alive":
+			return true
+		}
 	}
-	defer file.Close()
-	_, err = c.Write([]byte(header))
-	if err != nil {
-		slog.Error("failed to write response header", "error", err)
-		return
+	return req.ProtoMinor >= 1
+}
+
+// serve runs the handler, turning a panic into a 500 response if nothing
+// was sent yet, or into closing the connection if it was.
+func (s *Server) serve(w *response, req *Request, h Handler) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("handler panicked", "method", req.Method, "path", req.Path, "panic", r)
+			if w.sent || w.hijacked {
+				w.keepAlive = false
+				return
+			}
+			w.header, w.status, w.buf = make(Header), 0, nil
+			Error(w, 500, "internal error")
+		}
+	}()
+	h.ServeHTTP(w, req)
+}
+
+// logRequest writes the access log entry of req, with the negotiated TLS
+// parameters on HTTPS connections.
+func logRequest(req *Request) {
+	attrs := []any{"method", req.Method, "path", req.Path, "protocol", req.Proto, "host", req.Host}
+	if req.TLS != nil {
+		attrs = append(attrs,
+			"tls", tls.VersionName(req.TLS.Version),
+			"cipher", tls.CipherSuiteName(req.TLS.CipherSuite),
+			"server name", req.TLS.ServerName,
+			"alpn", req.TLS.NegotiatedProtocol,
+		)
 	}
-	_, err = io.Copy(c, file)
-	if err != nil {
-		slog.Error("failed to write response body", "error", err)
-		c.Write([]byte("ERR: failed to write response body\n"))
-		return
+	slog.Info("received request", attrs...)
+}
+
+// routes builds the handler of every configured host.
+func routes(cfg *Config) (Handler, error) {
+	vhosts := NewVirtualHosts()
+	for _, host := range cfg.Hosts {
+		files, err := NewFileServer(host.Root, host.Listings)
+		if err != nil {
+			return nil, fmt.Errorf("host %v: %w", host.Names, err)
+		}
+		router := NewRouter()
+		if host.Health != "" {
+			router.HandleFunc("GET", host.Health, func(w ResponseWriter, r *Request) {
+				writeJSON(w, 200, map[string]string{"status": "ok"})
+			})
+		}
+		router.Handle("GET", "/{path...}", files)
+		for _, name := range host.Names {
+			vhosts.Handle(name, router)
+		}
+		if host.Default {
+			vhosts.HandleDefault(router)
+		}
 	}
+	return Compress(vhosts), nil
 }
 
 func main() {
-	var cfg Config
-	flag.StringVar(&cfg.host, "host", "", "Host to bind the server to")
-	flag.IntVar(&cfg.port, "port", 0, "Port to bind the server to")
+	configPath := flag.String("config", "config.json", "Path to the JSON configuration file")
 	flag.Parse()
 
-	if cfg.port == 0 {
-		flag.Usage()
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+	handler, err := routes(cfg)
+	if err != nil {
+		slog.Error("failed to set up routes", "error", err)
 		os.Exit(1)
 	}
 	server := &Server{
-		host: cfg.host,
-		port: cfg.port,
+		addr:              cfg.Listen,
+		maxHeaderBytes:    cfg.MaxHeaderBytes,
+		maxBodyBytes:      cfg.MaxBodyBytes,
+		maxRequests:       cfg.MaxRequests,
+		readHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		readBodyTimeout:   time.Duration(cfg.ReadBodyTimeout),
+		writeTimeout:      time.Duration(cfg.WriteTimeout),
+		idleTimeout:       time.Duration(cfg.IdleTimeout),
+		limiter:           newConnLimiter(cfg.MaxConnections, cfg.MaxConnectionsPerIP),
+		handler:           handler,
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.serverConfig()
+		if err != nil {
+			slog.Error("failed to set up tls", "error", err)
+			os.Exit(1)
+		}
+		server.tlsAddr = cfg.TLS.Listen
+		server.tlsConfig = tlsConfig
+		server.redirectHTTP = cfg.TLS.RedirectHTTP
+		server.hsts = cfg.TLS.hsts()
 	}
 	server.Run()
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds the ranges of one request, so a client cannot make us
+// send a file many times over in a single response.
+const maxRanges = 32
+
+var errUnsatisfiable = errors.New("no range overlaps the content")
+
+// httpRange is a byte range of the content, resolved against its size.
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range field value (RFC 9110, section 14.1) for
+// content of the given size. Ranges that lie beyond the end are dropped;
+// errUnsatisfiable means none were left. Any other error means the field
+// is malformed and should be ignored.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, fmt.Errorf("unsupported range unit in %q", s)
+	}
+	var ranges []httpRange
+	specs := splitList([]string{spec})
+	if len(specs) == 0 || len(specs) > maxRanges {
+		return nil, fmt.Errorf("invalid number of ranges in %q", s)
+	}
+	for _, spec := range specs {
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("malformed range %q", spec)
+		}
+		var r httpRange
+		if first == "" {
+			// a suffix range: the last n bytes
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue // no bytes to send
+			}
+			r.length = min(n, size)
+			r.start = size - r.length
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = parseRangeInt(last); err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, fmt.Errorf("malformed range %q", spec)
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r.start = start
+			r.length = min(end, size-1) - start + 1
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("malformed range position %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		size int64
+		want []httpRange
+		err  error // errUnsatisfiable, or errMalformed for any other error
+	}{
+		{"first bytes", "bytes=0-499", 1000, []httpRange{{0, 500}}, nil},
+		{"middle", "bytes=500-999", 1000, []httpRange{{500, 500}}, nil},
+		{"open ended", "bytes=900-", 1000, []httpRange{{900, 100}}, nil},
+		{"suffix", "bytes=-100", 1000, []httpRange{{900, 100}}, nil},
+		{"suffix longer than content", "bytes=-5000", 1000, []httpRange{{0, 1000}}, nil},
+		{"end past content", "bytes=990-5000", 1000, []httpRange{{990, 10}}, nil},
+		{"single byte", "bytes=0-0", 1000, []httpRange{{0, 1}}, nil},
+		{"last byte", "bytes=999-999", 1000, []httpRange{{999, 1}}, nil},
+		{"several", "bytes=0-9, 20-29,-5", 1000, []httpRange{{0, 10}, {20, 10}, {995, 5}}, nil},
+		{"empty elements", "bytes=,0-9,,", 1000, []httpRange{{0, 10}}, nil},
+		{"unsatisfiable dropped", "bytes=0-9,2000-3000", 1000, []httpRange{{0, 10}}, nil},
+		{"leading zeros", "bytes=010-019", 1000, []httpRange{{10, 10}}, nil},
+
+		{"start at end", "bytes=1000-", 1000, nil, errUnsatisfiable},
+		{"start past end", "bytes=2000-3000", 1000, nil, errUnsatisfiable},
+		{"zero suffix", "bytes=-0", 1000, nil, errUnsatisfiable},
+		{"suffix of empty content", "bytes=-5", 0, nil, errUnsatisfiable},
+		{"empty content", "bytes=0-", 0, nil, errUnsatisfiable},
+
+		{"other unit", "items=0-9", 1000, nil, errMalformed},
+		{"no unit", "0-9", 1000, nil, errMalformed},
+		{"upper-case unit", "BYTES=0-9", 1000, nil, errMalformed},
+		{"no ranges", "bytes=", 1000, nil, errMalformed},
+		{"no dash", "bytes=5", 1000, nil, errMalformed},
+		{"only dash", "bytes=-", 1000, nil, errMalformed},
+		{"end before start", "bytes=9-0", 1000, nil, errMalformed},
+		{"negative start", "bytes=--5", 1000, nil, errMalformed},
+		{"signed", "bytes=+1-5", 1000, nil, errMalformed},
+		{"hex", "bytes=0x1-5", 1000, nil, errMalformed},
+		{"inner space", "bytes=0 -9", 1000, nil, errMalformed},
+		{"overflow", "bytes=0-99999999999999999999", 1000, nil, errMalformed},
+		{"one malformed of several", "bytes=0-9,x-y", 1000, nil, errMalformed},
+		{"too many", "bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", 1000, nil, errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.s, tt.size)
+			switch {
+			case tt.err == errMalformed:
+				if err == nil || errors.Is(err, errUnsatisfiable) {
+					t.Fatalf("parseRange(%q) = %v, %v; want a malformed range error", tt.s, got, err)
+				}
+			case !errors.Is(err, tt.err):
+				t.Fatalf("parseRange(%q) error = %v, want %v", tt.s, err, tt.err)
+			case !slices.Equal(got, tt.want):
+				t.Fatalf("parseRange(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+// errMalformed stands for any error other than errUnsatisfiable in
+// TestParseRange.
+var errMalformed = errors.New("malformed")
+
+func TestContentRange(t *testing.T) {
+	tests := []struct {
+		r    httpRange
+		size int64
+		want string
+	}{
+		{httpRange{0, 500}, 1000, "bytes 0-499/1000"},
+		{httpRange{999, 1}, 1000, "bytes 999-999/1000"},
+		{httpRange{0, 1}, 1, "bytes 0-0/1"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.contentRange(tt.size); got != tt.want {
+			t.Errorf("contentRange(%v, %d) = %q, want %q", tt.r, tt.size, got, tt.want)
+		}
+	}
+}
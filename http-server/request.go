@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Header maps canonical field names, such as "Content-Type", to their
+// values in the order they were received.
+type Header map[string][]string
+
+func (h Header) Get(key string) string {
+	if v := h[canonicalKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (h Header) Values(key string) []string {
+	return h[canonicalKey(key)]
+}
+
+func (h Header) Add(key, value string) {
+	key = canonicalKey(key)
+	h[key] = append(h[key], value)
+}
+
+func (h Header) Set(key, value string) {
+	h[canonicalKey(key)] = []string{value}
+}
+
+func (h Header) Del(key string) {
+	delete(h, canonicalKey(key))
+}
+
+// canonicalKey upper-cases the first letter of every dash-separated word
+// and lower-cases the rest: "content-TYPE" becomes "Content-Type".
+func canonicalKey(key string) string {
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		switch {
+		case upper && 'a' <= c && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case !upper && 'A' <= c && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+type Request struct {
+	Method     string
+	Target     string // request-target as sent, e.g. "/docs/a%20b.html?x=1"
+	Path       string // percent-decoded path of Target
+	RawQuery   string
+	Proto      string // "HTTP/1.0" or "HTTP/1.1"
+	ProtoMinor int
+	Header     Header
+	Host       string
+
+	// ContentLength is the body's length, or -1 when it is chunked and the
+	// length is not known up front.
+	ContentLength int64
+	Chunked       bool
+	Body          io.Reader // never nil; empty when there is no body
+	Trailer       Header    // chunked bodies only; filled in once Body is read to the end
+
+	RemoteAddr string
+	TLS        *tls.ConnectionState // nil for plain HTTP
+
+	params map[string]string // set by Router
+}
+
+// PathValue returns the value of the named Router pattern parameter, or ""
+// if there is none.
+func (req *Request) PathValue(name string) string {
+	return req.params[name]
+}
+
+// httpError is a problem with a request that the client is told about
+// with status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.status, statusText[e.status], e.msg)
+}
+
+func badRequest(format string, args ...any) error {
+	return &httpError{status: 400, msg: fmt.Sprintf(format, args...)}
+}
+
+var errLineTooLong = errors.New("line exceeds header size limit")
+
+// readRequest parses the request line and header fields of the next
+// request on br and sets up its body, which the caller reads from br
+// through Request.Body. The request line and fields together may take up
+// to maxHeaderBytes; a body longer than maxBodyBytes is refused.
+func readRequest(br *bufio.Reader, maxHeaderBytes int, maxBodyBytes int64) (*Request, error) {
+	budget := maxHeaderBytes
+
+	// a client may send empty lines between requests (RFC 9112, section 2.2)
+	var line string
+	for line == "" {
+		var err error
+		line, err = readLine(br, &budget)
+		if errors.Is(err, errLineTooLong) {
+			return nil, &httpError{status: 414, msg: "request line too long"}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req := &Request{Header: make(Header)}
+	if err := req.parseRequestLine(line); err != nil {
+		return nil, err
+	}
+	if err := readHeader(br, &budget, req.Header); err != nil {
+		if errors.Is(err, errLineTooLong) {
+			return nil, &httpError{status: 431, msg: "request header fields too large"}
+		}
+		return nil, err
+	}
+	if err := req.parseHost(); err != nil {
+		return nil, err
+	}
+	if err := req.setupBody(br, maxHeaderBytes, maxBodyBytes); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readLine reads a line ending in CRLF or a bare LF and returns it without
+// the line ending. The line is charged to *budget; errLineTooLong means it
+// did not fit.
+func readLine(br *bufio.Reader, budget *int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(line)+len(chunk) > *budget {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+	*budget -= len(line)
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
+func (req *Request) parseRequestLine(line string) error {
+	method, rest, ok1 := strings.Cut(line, " ")
+	target, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || strings.Contains(proto, " ") {
+		return badRequest("malformed request line %q", line)
+	}
+	if !isToken(method) {
+		return badRequest("invalid method %q", method)
+	}
+	if !validTarget(target) {
+		// a bare CR or the like would end up in headers that echo the target
+		return badRequest("invalid request target %q", target)
+	}
+
+	major, minor, ok := parseVersion(proto)
+	if !ok {
+		return badRequest("malformed HTTP version %q", proto)
+	}
+	if major != 1 || minor > 1 {
+		return &httpError{status: 505, msg: fmt.Sprintf("%s is not supported", proto)}
+	}
+	req.Method, req.Target, req.Proto, req.ProtoMinor = method, target, proto, minor
+
+	switch {
+	case strings.HasPrefix(target, "/"):
+		// origin-form, the usual case
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		// absolute-form, as sent to proxies; the host in it wins over Host
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return badRequest("malformed request target %q", target)
+		}
+		req.Host = u.Host
+		target = u.RequestURI()
+	case target == "*" && method == "OPTIONS":
+		req.Path = "*"
+		return nil
+	default:
+		return badRequest("malformed request target %q", target)
+	}
+
+	rawPath, query, _ := strings.Cut(target, "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return badRequest("malformed path %q", rawPath)
+	}
+	req.Path, req.RawQuery = path, query
+	return nil
+}
+
+// parseVersion parses "HTTP/x.y" with single-digit x and y.
+func parseVersion(proto string) (major, minor int, ok bool) {
+	if len(proto) != len("HTTP/1.1") || !strings.HasPrefix(proto, "HTTP/") || proto[6] != '.' {
+		return 0, 0, false
+	}
+	major, minor = int(proto[5]-'0'), int(proto[7]-'0')
+	if major < 0 || major > 9 || minor < 0 || minor > 9 {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// readHeader reads header fields up to the empty line that ends them.
+// Obsolete line folding, a line starting with a space or tab, continues
+// the previous field and is replaced with a single space (RFC 9112,
+// section 5.2).
+func readHeader(br *bufio.Reader, budget *int, h Header) error {
+	var lastKey string
+	for {
+		line, err := readLine(br, budget)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey == "" {
+				return badRequest("folded line without a field to continue")
+			}
+			value := strings.Trim(line, " \t")
+			if !validFieldValue(value) {
+				return badRequest("invalid value for field %s", lastKey)
+			}
+			values := h[lastKey]
+			values[len(values)-1] = strings.TrimRight(values[len(values)-1]+" "+value, " ")
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !isToken(name) {
+			// this also rejects whitespace before the colon, as RFC 9112 asks
+			return badRequest("malformed header field %q", line)
+		}
+		value = strings.Trim(value, " \t")
+		if !validFieldValue(value) {
+			return badRequest("invalid value for field %s", name)
+		}
+		lastKey = canonicalKey(name)
+		h[lastKey] = append(h[lastKey], value)
+	}
+}
+
+// parseHost checks the Host field, which HTTP/1.1 requests must carry
+// exactly once.
+func (req *Request) parseHost() error {
+	hosts := req.Header.Values("Host")
+	switch {
+	case len(hosts) > 1:
+		return badRequest("multiple Host fields")
+	case len(hosts) == 0 && req.ProtoMinor >= 1:
+		return badRequest("missing Host field")
+	case len(hosts) == 1 && req.Host == "":
+		if strings.ContainsAny(hosts[0], " /?#@") {
+			return badRequest("invalid Host %q", hosts[0])
+		}
+		req.Host = hosts[0]
+	}
+	return nil
+}
+
+// setupBody works out how the body is framed (RFC 9112, section 6.3) and
+// sets Body to read exactly that much from br.
+func (req *Request) setupBody(br *bufio.Reader, maxHeaderBytes int, maxBodyBytes int64) error {
+	te := req.Header.Values("Transfer-Encoding")
+	cl := req.Header.Values("Content-Length")
+
+	switch {
+	case len(te) > 0:
+		if len(cl) > 0 {
+			// a classic request smuggling vector; refuse rather than guess
+			return badRequest("both Transfer-Encoding and Content-Length")
+		}
+		if req.ProtoMinor == 0 {
+			return badRequest("Transfer-Encoding in an HTTP/1.0 request")
+		}
+		codings := splitList(te)
+		if len(codings) == 0 || !strings.EqualFold(codings[len(codings)-1], "chunked") {
+			return badRequest("chunked must be the final transfer coding")
+		}
+		if len(codings) > 1 {
+			return &httpError{status: 501, msg: fmt.Sprintf("unsupported transfer coding %q", codings[0])}
+		}
+		req.Chunked = true
+		req.ContentLength = -1
+		req.Trailer = make(Header)
+		req.Body = &maxBytesReader{
+			r: &chunkedReader{br: br, trailer: req.Trailer, maxTrailerBytes: maxHeaderBytes},
+			n: maxBodyBytes,
+		}
+
+	case len(cl) > 0:
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		if n > maxBodyBytes {
+			return &httpError{status: 413, msg: fmt.Sprintf("body of %d bytes exceeds the limit of %d", n, maxBodyBytes)}
+		}
+		req.ContentLength = n
+		req.Body = &fixedLengthReader{r: br, n: n}
+
+	default:
+		req.Body = eofReader{}
+	}
+	return nil
+}
+
+// parseContentLength accepts repeated Content-Length values only if they
+// all agree.
+func parseContentLength(values []string) (int64, error) {
+	var n int64 = -1
+	for _, v := range splitList(values) {
+		if v == "" || strings.TrimLeft(v, "0123456789") != "" {
+			return 0, badRequest("invalid Content-Length %q", v)
+		}
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, badRequest("invalid Content-Length %q", v)
+		}
+		if n >= 0 && parsed != n {
+			return 0, badRequest("conflicting Content-Length values")
+		}
+		n = parsed
+	}
+	if n < 0 {
+		return 0, badRequest("empty Content-Length")
+	}
+	return n, nil
+}
+
+// splitList splits comma-separated field values into their trimmed,
+// non-empty elements.
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			if elem = strings.Trim(elem, " \t"); elem != "" {
+				out = append(out, elem)
+			}
+		}
+	}
+	return out
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token, the syntax of
+// methods and field names.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validTarget reports whether target is free of spaces and control
+// characters, none of which may appear in a request-target unencoded.
+func validTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validFieldValue rejects control characters other than tab, which
+// includes stray CR and NUL bytes.
+func validFieldValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
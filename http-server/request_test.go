@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// errStatus returns the status an error from readRequest is answered
+// with, or 0 if it is not an httpError.
+func errStatus(err error) int {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.status
+	}
+	return 0
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int // 0 when the request parses
+	}{
+		{"minimal", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", 0},
+		{"bare LF", "GET / HTTP/1.1\nHost: a\n\n", 0},
+		{"leading empty lines", "\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n", 0},
+		{"HTTP/1.0 without Host", "GET / HTTP/1.0\r\n\r\n", 0},
+		{"OPTIONS asterisk", "OPTIONS * HTTP/1.1\r\nHost: a\r\n\r\n", 0},
+		{"absolute form", "GET http://a/b HTTP/1.1\r\nHost: c\r\n\r\n", 0},
+
+		{"missing version", "GET /\r\nHost: a\r\n\r\n", 400},
+		{"extra space", "GET  / HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"trailing space", "GET / HTTP/1.1 \r\nHost: a\r\n\r\n", 400},
+		{"invalid method", "G(T / HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"relative target", "GET a HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"asterisk with GET", "GET * HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"bad escape", "GET /%zz HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"CR in target", "GET /a\rb HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"NUL in target", "GET /a\x00b HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"DEL in target", "GET /a\x7fb HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"tab in target", "GET /a\tb HTTP/1.1\r\nHost: a\r\n\r\n", 400},
+		{"malformed version", "GET / HTTP/1.x\r\nHost: a\r\n\r\n", 400},
+		{"lower-case version", "GET / http/1.1\r\nHost: a\r\n\r\n", 400},
+		{"HTTP/2.0", "GET / HTTP/2.0\r\nHost: a\r\n\r\n", 505},
+		{"HTTP/1.2", "GET / HTTP/1.2\r\nHost: a\r\n\r\n", 505},
+
+		{"missing Host", "GET / HTTP/1.1\r\n\r\n", 400},
+		{"two Hosts", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", 400},
+		{"Host with path", "GET / HTTP/1.1\r\nHost: a/b\r\n\r\n", 400},
+		{"Host with userinfo", "GET / HTTP/1.1\r\nHost: u@a\r\n\r\n", 400},
+		{"space before colon", "GET / HTTP/1.1\r\nHost : a\r\n\r\n", 400},
+		{"field without colon", "GET / HTTP/1.1\r\nHost: a\r\nX\r\n\r\n", 400},
+		{"NUL in field value", "GET / HTTP/1.1\r\nHost: a\r\nX: a\x00b\r\n\r\n", 400},
+		{"CR in field value", "GET / HTTP/1.1\r\nHost: a\r\nX: a\rb\r\n\r\n", 400},
+		{"fold without field", "GET / HTTP/1.1\r\n x\r\nHost: a\r\n\r\n", 400},
+
+		{"request line too long", "GET /" + strings.Repeat("a", 2000) + " HTTP/1.1\r\n\r\n", 414},
+		{"header too large", "GET / HTTP/1.1\r\nHost: a\r\nX: " + strings.Repeat("a", 2000) + "\r\n\r\n", 431},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRequest(bufio.NewReader(strings.NewReader(tt.raw)), 1024, 1024)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("readRequest: %v", err)
+				}
+				return
+			}
+			if got := errStatus(err); got != tt.status {
+				t.Fatalf("readRequest error = %v, want status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestReadRequestFields(t *testing.T) {
+	raw := "GET http://Example.com:8080/a%20b?x=1 HTTP/1.1\r\n" +
+		"Host: ignored\r\n" +
+		"x-long: one\r\n" +
+		"\t two \r\n" +
+		"Accept: a\r\n" +
+		"accept: b\r\n" +
+		"\r\n"
+	req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), 1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Host != "Example.com:8080" {
+		t.Errorf("Host = %q, want the absolute-form host", req.Host)
+	}
+	if req.Path != "/a b" || req.RawQuery != "x=1" {
+		t.Errorf("Path, RawQuery = %q, %q", req.Path, req.RawQuery)
+	}
+	if got := req.Header.Get("X-Long"); got != "one two" {
+		t.Errorf("folded field = %q, want %q", got, "one two")
+	}
+	if got := req.Header.Values("ACCEPT"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Accept = %q", got)
+	}
+}
+
+func TestReadRequestBodyFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		status int
+		want   string // the body read, when the request parses
+	}{
+		{"no body", "", "", 0, ""},
+		{"Content-Length", "Content-Length: 5\r\n", "hello", 0, "hello"},
+		{"repeated equal Content-Length", "Content-Length: 5\r\nContent-Length: 5\r\n", "hello", 0, "hello"},
+		{"equal Content-Length list", "Content-Length: 5, 5\r\n", "hello", 0, "hello"},
+		{"conflicting Content-Length", "Content-Length: 5\r\nContent-Length: 6\r\n", "hello!", 400, ""},
+		{"conflicting Content-Length list", "Content-Length: 5, 6\r\n", "hello!", 400, ""},
+		{"signed Content-Length", "Content-Length: +5\r\n", "hello", 400, ""},
+		{"negative Content-Length", "Content-Length: -1\r\n", "", 400, ""},
+		{"hex Content-Length", "Content-Length: 0x5\r\n", "hello", 400, ""},
+		{"empty Content-Length", "Content-Length: \r\n", "", 400, ""},
+		{"overflowing Content-Length", "Content-Length: 99999999999999999999\r\n", "", 400, ""},
+		{"Content-Length over limit", "Content-Length: 1025\r\n", "", 413, ""},
+		{"chunked", "Transfer-Encoding: chunked\r\n", "5\r\nhello\r\n0\r\n\r\n", 0, "hello"},
+		{"chunked in any case", "Transfer-Encoding: Chunked\r\n", "0\r\n\r\n", 0, ""},
+		{"TE and CL", "Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", "5\r\nhello\r\n0\r\n\r\n", 400, ""},
+		{"CL and TE", "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", "5\r\nhello\r\n0\r\n\r\n", 400, ""},
+		{"chunked not last", "Transfer-Encoding: chunked, gzip\r\n", "", 400, ""},
+		{"unknown coding", "Transfer-Encoding: gzip\r\n", "", 400, ""},
+		{"empty Transfer-Encoding", "Transfer-Encoding: \r\n", "", 400, ""},
+		{"gzip then chunked", "Transfer-Encoding: gzip, chunked\r\n", "", 501, ""},
+		{"split gzip then chunked", "Transfer-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n", "", 501, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\nHost: a\r\n" + tt.header + "\r\n" + tt.body
+			req, err := readRequest(bufio.NewReader(strings.NewReader(raw)), 1024, 1024)
+			if tt.status != 0 {
+				if got := errStatus(err); got != tt.status {
+					t.Fatalf("readRequest error = %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readRequest: %v", err)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransferEncodingInHTTP10(t *testing.T) {
+	raw := "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"
+	_, err := readRequest(bufio.NewReader(strings.NewReader(raw)), 1024, 1024)
+	if got := errStatus(err); got != 400 {
+		t.Fatalf("readRequest error = %v, want status 400", err)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	raw := "POST /1 HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /2 HTTP/1.1\r\nHost: a\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+	for _, want := range []string{"/1", "/2"} {
+		req, err := readRequest(br, 1024, 1024)
+		if err != nil {
+			t.Fatalf("readRequest %s: %v", want, err)
+		}
+		if req.Path != want {
+			t.Fatalf("Path = %q, want %q", req.Path, want)
+		}
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := readRequest(br, 1024, 1024); err != io.EOF {
+		t.Fatalf("after the last request: err = %v, want io.EOF", err)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	tests := map[string]string{
+		"content-type":    "Content-Type",
+		"CONTENT-LENGTH":  "Content-Length",
+		"x-forwarded-for": "X-Forwarded-For",
+		"a--b":            "A--B",
+		"host":            "Host",
+		"":                "",
+	}
+	for in, want := range tests {
+		if got := canonicalKey(in); got != want {
+			t.Errorf("canonicalKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the date format of HTTP header fields such as Date.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var statusText = map[int]string{
+	101: "Switching Protocols",
+	200: "OK",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	304: "Not Modified",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	408: "Request Timeout",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	416: "Range Not Satisfiable",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	503: "Service Unavailable",
+	505: "HTTP Version Not Supported",
+}
+
+// bufferLimit is how much of a body is held back to work out its
+// Content-Length when the handler did not set one.
+const bufferLimit = 4096
+
+var errBodyNotAllowed = errors.New("response status or method does not allow a body")
+var errContentLength = errors.New("wrote more than the declared Content-Length")
+var errHijacked = errors.New("connection has been hijacked")
+
+// Hijacker is implemented by ResponseWriters that can hand the connection
+// over to the handler, for protocols such as WebSocket that take over
+// after an HTTP exchange. The server writes nothing more on a hijacked
+// connection and closes it once the handler returns.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// response is the ResponseWriter of one request on a connection.
+type response struct {
+	conn      *bufio.Writer
+	req       *Request // nil when answering a request that could not be parsed
+	netConn   net.Conn
+	br        *bufio.Reader
+	header    Header
+	status    int    // 0 until WriteHeader
+	sent      bool   // the status line and header fields are out
+	buf       []byte // body held back until the header is sent
+	written   int64  // body bytes sent
+	length    int64  // declared Content-Length, or -1
+	chunked   bool   // the body is sent with the chunked transfer coding
+	keepAlive bool   // the connection may serve another request afterwards
+	hijacked  bool   // the handler has taken over the connection
+}
+
+func newResponse(conn *bufio.Writer, req *Request, keepAlive bool) *response {
+	return &response{conn: conn, req: req, header: make(Header), length: -1, keepAlive: keepAlive}
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(status int) {
+	if w.status != 0 {
+		slog.Warn("superfluous WriteHeader call", "status", status)
+		return
+	}
+	w.status = status
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, errHijacked
+	}
+	if w.status == 0 {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, errBodyNotAllowed
+	}
+	if !w.sent {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) <= bufferLimit {
+			return len(p), nil
+		}
+		if err := w.sendHeader(false); err != nil {
+			return 0, err
+		}
+		buf := w.buf
+		w.buf = nil
+		_, err := w.writeBody(buf)
+		return len(p), err
+	}
+	return w.writeBody(p)
+}
+
+func (w *response) writeBody(p []byte) (int, error) {
+	if w.length >= 0 && w.written+int64(len(p)) > w.length {
+		return 0, errContentLength
+	}
+	w.written += int64(len(p))
+	if w.isHead() || len(p) == 0 {
+		return len(p), nil
+	}
+	if w.chunked {
+		fmt.Fprintf(w.conn, "%x\r\n", len(p))
+		if _, err := w.conn.Write(p); err != nil {
+			return 0, err
+		}
+		_, err := io.WriteString(w.conn, "\r\n")
+		return len(p), err
+	}
+	return w.conn.Write(p)
+}
+
+// sendHeader writes the status line and header fields. final means the
+// handler is done, so everything it wrote is in buf.
+func (w *response) sendHeader(final bool) error {
+	w.sent = true
+	h := w.header
+	h.Del("Transfer-Encoding") // the framing is ours to choose
+	if strings.EqualFold(h.Get("Connection"), "close") {
+		w.keepAlive = false
+	}
+
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			slog.Error("handler set an invalid Content-Length", "value", cl)
+			h.Del("Content-Length")
+		} else {
+			w.length = n
+		}
+	}
+	switch {
+	case !bodyAllowed(w.status):
+		h.Del("Content-Length")
+		if w.status == 304 {
+			h.Del("Content-Type") // it describes a body the client already has
+		}
+	case w.length >= 0:
+	case final && (!w.isHead() || len(w.buf) > 0):
+		w.length = int64(len(w.buf))
+		h.Set("Content-Length", strconv.FormatInt(w.length, 10))
+	case w.isHead():
+		// no body follows either way, so the connection can stay open
+	case w.req != nil && w.req.ProtoMinor >= 1:
+		h.Set("Transfer-Encoding", "chunked")
+		w.chunked = true
+	default:
+		// the body's end can only be told by closing the connection
+		w.keepAlive = false
+	}
+	if h.Get("Content-Type") == "" && w.length != 0 && bodyAllowed(w.status) {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	return writeHeader(w.conn, w.status, h, w.keepAlive)
+}
+
+// Hijack hands the connection over, with its buffered reader and writer.
+// It fails once the response header has been sent.
+func (w *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	switch {
+	case w.hijacked:
+		return nil, nil, errHijacked
+	case w.sent || w.netConn == nil:
+		return nil, nil, errors.New("connection cannot be hijacked after the response has started")
+	}
+	w.hijacked = true
+	w.keepAlive = false
+	w.netConn.SetReadDeadline(time.Time{}) // the new protocol sets its own
+
+	return w.netConn, bufio.NewReadWriter(w.br, w.conn), nil
+}
+
+// finish completes the response after the handler has returned.
+func (w *response) finish() error {
+	if w.hijacked {
+		return nil
+	}
+	if w.status == 0 {
+		w.WriteHeader(200)
+	}
+	if !w.sent {
+		if err := w.sendHeader(true); err != nil {
+			return err
+		}
+		if _, err := w.writeBody(w.buf); err != nil {
+			return err
+		}
+		w.buf = nil
+	}
+	if w.chunked {
+		if _, err := io.WriteString(w.conn, "0\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	if w.length >= 0 && w.written < w.length && bodyAllowed(w.status) {
+		// the client is waiting for bytes that will never come
+		slog.Error("handler wrote less than the declared Content-Length", "declared", w.length, "written", w.written)
+		w.keepAlive = false
+	}
+	return nil
+}
+
+func (w *response) isHead() bool {
+	return w.req != nil && w.req.Method == "HEAD"
+}
+
+// bodyAllowed reports whether a response with status may carry a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+// writeHeader writes the status line and header fields. Unless keepAlive
+// is set, it tells the client the connection closes after this response.
+// Fields with an invalid name or value are dropped and logged, so no
+// handler can inject fields of its own.
+func writeHeader(w io.Writer, status int, h Header, keepAlive bool) error {
+	if keepAlive {
+		h.Set("Connection", "keep-alive")
+	} else {
+		h.Set("Connection", "close")
+	}
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, statusText[status])
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		if !isToken(key) {
+			slog.Error("dropping response header field with an invalid name", "field", key)
+			continue
+		}
+		for _, v := range h[key] {
+			if !validFieldValue(v) {
+				// a CR or LF written as is would split the response
+				slog.Error("dropping response header field with an invalid value", "field", key, "value", v)
+				continue
+			}
+			fmt.Fprintf(&b, "%s: %s\r\n", key, v)
+		}
+	}
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
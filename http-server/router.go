@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// knownMethods are the methods the server implements; any other gets 501.
+var knownMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Router dispatches requests by method and path. Patterns are made of
+// literal segments, "{name}" segments that match any one non-empty
+// segment, and an optional final "{name...}" that matches the rest of the
+// path; handlers read the matched values with Request.PathValue. When
+// several patterns match, the one with the most literal segments wins.
+//
+// GET routes also answer HEAD. A path that matches only routes for other
+// methods gets 405 Method Not Allowed with an Allow header, and OPTIONS is
+// answered with the allowed methods unless a route handles it.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for method and pattern. It panics on a malformed
+// pattern, as that is a programming error.
+func (rt *Router) Handle(method, pattern string, h Handler) {
+	if !isToken(method) {
+		panic(fmt.Sprintf("router: invalid method %q", method))
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("router: pattern %q must start with /", pattern))
+	}
+	segments := strings.Split(pattern[1:], "/")
+	for i, seg := range segments {
+		if strings.HasSuffix(seg, "...}") && i != len(segments)-1 {
+			panic(fmt.Sprintf("router: %s must be the last segment of %q", seg, pattern))
+		}
+	}
+	rt.routes = append(rt.routes, &route{method: method, segments: segments, handler: h})
+}
+
+func (rt *Router) HandleFunc(method, pattern string, f func(ResponseWriter, *Request)) {
+	rt.Handle(method, pattern, HandlerFunc(f))
+}
+
+func (rt *Router) ServeHTTP(w ResponseWriter, r *Request) {
+	if !slices.Contains(knownMethods, r.Method) {
+		Error(w, 501, fmt.Sprintf("unsupported method %s", r.Method))
+		return
+	}
+	if r.Path == "*" {
+		// OPTIONS * asks about the server as a whole
+		w.Header().Set("Allow", strings.Join(knownMethods, ", "))
+		w.WriteHeader(204)
+		return
+	}
+
+	var best *route
+	var bestParams map[string]string
+	bestScore := -1
+	allowed := map[string]bool{}
+	for _, rte := range rt.routes {
+		params, score, ok := rte.match(r.Path)
+		if !ok {
+			continue
+		}
+		allowed[rte.method] = true
+		if rte.method == "GET" {
+			allowed["HEAD"] = true
+		}
+		if rte.method != r.Method && !(r.Method == "HEAD" && rte.method == "GET") {
+			continue
+		}
+		if rte.method == "HEAD" {
+			score++ // an explicit HEAD route beats the GET one it would fall back to
+		}
+		if score > bestScore {
+			best, bestParams, bestScore = rte, params, score
+		}
+	}
+
+	switch {
+	case best != nil:
+		r.params = bestParams
+		best.handler.ServeHTTP(w, r)
+	case len(allowed) == 0:
+		Error(w, 404, r.Path)
+	case r.Method == "OPTIONS":
+		allowed["OPTIONS"] = true
+		w.Header().Set("Allow", allowHeader(allowed))
+		w.WriteHeader(204)
+	default:
+		allowed["OPTIONS"] = true
+		w.Header().Set("Allow", allowHeader(allowed))
+		Error(w, 405, fmt.Sprintf("%s is not allowed for %s", r.Method, r.Path))
+	}
+}
+
+// match reports whether path matches the route, with the values of its
+// parameters and a score that is higher for more specific patterns.
+func (rte *route) match(path string) (map[string]string, int, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var params map[string]string
+	score := 0
+	for i, seg := range rte.segments {
+		name, isParam := strings.CutPrefix(seg, "{")
+		if !isParam {
+			if i >= len(parts) || parts[i] != seg {
+				return nil, 0, false
+			}
+			score += 2
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		name = strings.TrimSuffix(name, "}")
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			params[rest] = strings.Join(parts[min(i, len(parts)):], "/")
+			return params, score, true
+		}
+		if i >= len(parts) || parts[i] == "" {
+			return nil, 0, false
+		}
+		params[name] = parts[i]
+		score++
+	}
+	if len(parts) != len(rte.segments) {
+		return nil, 0, false
+	}
+	return params, score, true
+}
+
+// allowHeader lists the methods in the order of knownMethods.
+func allowHeader(allowed map[string]bool) string {
+	var methods []string
+	for _, m := range knownMethods {
+		if allowed[m] {
+			methods = append(methods, m)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// recorder is a ResponseWriter that keeps what the handler wrote.
+type recorder struct {
+	status int
+	header Header
+	body   strings.Builder
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(Header)}
+}
+
+func (r *recorder) Header() Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(p)
+}
+
+// serveRequest runs h for a request with method and path.
+func serveRequest(h Handler, method, path string) *recorder {
+	w := newRecorder()
+	h.ServeHTTP(w, &Request{Method: method, Path: path, Header: make(Header), Body: eofReader{}})
+	return w
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	reply := func(name string, params ...string) HandlerFunc {
+		return func(w ResponseWriter, r *Request) {
+			out := []string{name}
+			for _, p := range params {
+				out = append(out, p+"="+r.PathValue(p))
+			}
+			w.Write([]byte(strings.Join(out, " ")))
+		}
+	}
+	router.Handle("GET", "/users", reply("list"))
+	router.Handle("POST", "/users", reply("create"))
+	router.Handle("GET", "/users/{id}", reply("user", "id"))
+	router.Handle("GET", "/users/me", reply("me"))
+	router.Handle("DELETE", "/users/{id}", reply("delete", "id"))
+	router.Handle("HEAD", "/users/me", reply("head me"))
+	router.Handle("GET", "/files/{path...}", reply("file", "path"))
+	router.Handle("PUT", "/", reply("root"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string // for 2xx responses
+		allow  string
+	}{
+		{"literal", "GET", "/users", 200, "list", ""},
+		{"by method", "POST", "/users", 200, "create", ""},
+		{"parameter", "GET", "/users/42", 200, "user id=42", ""},
+		{"literal beats parameter", "GET", "/users/me", 200, "me", ""},
+		{"HEAD falls back to GET", "HEAD", "/users/42", 200, "user id=42", ""},
+		{"explicit HEAD route", "HEAD", "/users/me", 200, "head me", ""},
+		{"empty parameter", "GET", "/users/", 404, "", ""},
+		{"too many segments", "GET", "/users/42/x", 404, "", ""},
+		{"wildcard", "GET", "/files/a/b/c.txt", 200, "file path=a/b/c.txt", ""},
+		{"empty wildcard", "GET", "/files/", 200, "file path=", ""},
+		{"wildcard matches the bare prefix", "GET", "/files", 200, "file path=", ""},
+		{"root", "PUT", "/", 200, "root", ""},
+		{"not found", "GET", "/nope", 404, "", ""},
+		{"method not allowed", "PATCH", "/users/42", 405, "", "GET, HEAD, DELETE, OPTIONS"},
+		{"method not allowed on a literal", "DELETE", "/users", 405, "", "GET, HEAD, POST, OPTIONS"},
+		{"OPTIONS", "OPTIONS", "/users", 204, "", "GET, HEAD, POST, OPTIONS"},
+		{"OPTIONS asterisk", "OPTIONS", "*", 204, "", strings.Join(knownMethods, ", ")},
+		{"unknown method", "BREW", "/users", 501, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRequest(router, tt.method, tt.path)
+			if w.status != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", w.status, tt.status, w.body.String())
+			}
+			if tt.status < 300 && w.body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.body.String(), tt.body)
+			}
+			if got := w.header.Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRouterBadPatterns(t *testing.T) {
+	tests := []struct {
+		name, method, pattern string
+	}{
+		{"relative", "GET", "users"},
+		{"wildcard not last", "GET", "/{path...}/x"},
+		{"bad method", "G T", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Handle(%q, %q) did not panic", tt.method, tt.pattern)
+				}
+			}()
+			NewRouter().Handle(tt.method, tt.pattern, HandlerFunc(func(ResponseWriter, *Request) {}))
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	handler, err := routes(&Config{Hosts: []HostConfig{{Default: true, Root: "www"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		maxHeaderBytes:    1024,
+		maxBodyBytes:      1024,
+		maxRequests:       100,
+		readHeaderTimeout: time.Second,
+		readBodyTimeout:   time.Second,
+		writeTimeout:      time.Second,
+		idleTimeout:       time.Second,
+		handler:           handler,
+	}
+}
+
+// exchange writes raw to a connection served by s and returns everything
+// s sends back before closing it.
+func exchange(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConnection(conn, s.handler)
+	go io.WriteString(client, raw)
+	resp, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp)
+}
+
+// wireResponse is a response read back by parseResponses.
+type wireResponse struct {
+	status int
+	header Header
+	body   string
+}
+
+// parseResponses splits what a connection sent back into responses framed
+// by Content-Length.
+func parseResponses(t *testing.T, raw string) []wireResponse {
+	t.Helper()
+	br := bufio.NewReader(strings.NewReader(raw))
+	var out []wireResponse
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return out
+		}
+		var resp wireResponse
+		if _, err := fmt.Sscanf(line, "HTTP/1.1 %d", &resp.status); err != nil {
+			t.Fatalf("bad status line %q in %q", line, raw)
+		}
+		resp.header = Header{}
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("truncated header in %q", raw)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				break
+			}
+			key, value, _ := strings.Cut(line, ": ")
+			resp.header.Add(key, value)
+		}
+		n, _ := strconv.Atoi(resp.header.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Fatalf("truncated body in %q", raw)
+		}
+		resp.body = string(body)
+		out = append(out, resp)
+	}
+}
+
+func TestHandleConnectionErrors(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name   string
+		raw    string
+		status string
+	}{
+		{"malformed request line", "GET /\r\n\r\n", "400 Bad Request"},
+		{"missing Host", "GET / HTTP/1.1\r\n\r\n", "400 Bad Request"},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: a\r\n\r\n", "505 HTTP Version Not Supported"},
+		{"header too large", "GET / HTTP/1.1\r\nX: " + strings.Repeat("a", 2000) + "\r\n\r\n", "431 Request Header Fields Too Large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := exchange(t, s, tt.raw)
+			if !strings.HasPrefix(resp, "HTTP/1.1 "+tt.status+"\r\n") {
+				t.Fatalf("response = %q, want status %s", resp, tt.status)
+			}
+			if !strings.Contains(resp, "\r\nConnection: close\r\n") {
+				t.Fatalf("response = %q, want Connection: close", resp)
+			}
+		})
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	get := func(path, extra string) string {
+		return "GET " + path + " HTTP/1.1\r\nHost: a\r\n" + extra + "\r\n"
+	}
+	tests := []struct {
+		name        string
+		maxRequests int
+		raw         string
+		want        []int    // statuses, in order
+		connection  []string // Connection of each response
+	}{
+		{
+			"pipelined",
+			100,
+			get("/", "") + get("/missing", "") + get("/", "Connection: close\r\n"),
+			[]int{200, 404, 200},
+			[]string{"keep-alive", "keep-alive", "close"},
+		},
+		{
+			"unread body is skipped",
+			100,
+			"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello" + get("/", "Connection: close\r\n"),
+			[]int{405, 200},
+			[]string{"keep-alive", "close"},
+		},
+		{
+			"HTTP/1.0 closes by default",
+			100,
+			"GET / HTTP/1.0\r\n\r\n" + get("/", ""),
+			[]int{200},
+			[]string{"close"},
+		},
+		{
+			"HTTP/1.0 keep-alive",
+			100,
+			"GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n" + get("/", "Connection: close\r\n"),
+			[]int{200, 200},
+			[]string{"keep-alive", "close"},
+		},
+		{
+			"request cap",
+			2,
+			get("/", "") + get("/", "") + get("/", ""),
+			[]int{200, 200},
+			[]string{"keep-alive", "close"},
+		},
+		{
+			"error closes",
+			100,
+			get("/", "") + "GET /\r\n\r\n" + get("/", ""),
+			[]int{200, 400},
+			[]string{"keep-alive", "close"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.maxRequests = tt.maxRequests
+			resps := parseResponses(t, exchange(t, s, tt.raw))
+			if len(resps) != len(tt.want) {
+				t.Fatalf("got %d responses, want %d", len(resps), len(tt.want))
+			}
+			for i, resp := range resps {
+				if resp.status != tt.want[i] || resp.header.Get("Connection") != tt.connection[i] {
+					t.Errorf("response %d = %d, Connection %q; want %d, %q",
+						i, resp.status, resp.header.Get("Connection"), tt.want[i], tt.connection[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.idleTimeout = 50 * time.Millisecond
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConnection(conn, s.handler)
+
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	done := make(chan string)
+	go func() {
+		resp, _ := io.ReadAll(client)
+		done <- string(resp)
+	}()
+	select {
+	case resp := <-done:
+		if resps := parseResponses(t, resp); len(resps) != 1 || resps[0].status != 200 {
+			t.Fatalf("responses = %+v, want one 200", resps)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the idle connection was not closed")
+	}
+}
+
+func TestResponseFraming(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("GET", "/small", func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "hello")
+	})
+	router.HandleFunc("GET", "/large", func(w ResponseWriter, r *Request) {
+		w.Write([]byte(strings.Repeat("a", 2*bufferLimit)))
+	})
+	router.HandleFunc("GET", "/empty", func(w ResponseWriter, r *Request) {
+		w.WriteHeader(204)
+	})
+	router.HandleFunc("GET", "/panic", func(w ResponseWriter, r *Request) {
+		panic("boom")
+	})
+	router.HandleFunc("GET", "/short", func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Length", "10")
+		io.WriteString(w, "hello")
+	})
+	s := newTestServer(t)
+	s.handler = router
+
+	const internalError = "500 Internal Server Error: internal error\n"
+	tests := []struct {
+		name          string
+		method, path  string
+		status        int
+		contentLength string
+		body          string // what follows the header on the wire
+		keepAlive     bool
+	}{
+		{"buffered body gets a Content-Length", "GET", "/small", 200, "5", "hello", true},
+		{"HEAD keeps the length but drops the body", "HEAD", "/small", 200, "5", "", true},
+		{"unbuffered body is chunked", "GET", "/large", 200, "", fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", 2*bufferLimit, strings.Repeat("a", 2*bufferLimit)), true},
+		{"no body", "GET", "/empty", 204, "", "", true},
+		{"panic", "GET", "/panic", 500, strconv.Itoa(len(internalError)), internalError, true},
+		{"short body closes", "GET", "/short", 200, "10", "hello", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a second request shows whether the connection was kept open
+			raw := exchange(t, s, tt.method+" "+tt.path+" HTTP/1.1\r\nHost: a\r\n\r\n"+
+				"GET /small HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+			head, rest, _ := strings.Cut(raw, "\r\n\r\n")
+			if !strings.HasPrefix(head, fmt.Sprintf("HTTP/1.1 %d ", tt.status)) {
+				t.Fatalf("response = %q, want status %d", head, tt.status)
+			}
+			if got := headerValue(head, "Content-Length"); got != tt.contentLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.contentLength)
+			}
+			body, next, keptAlive := strings.Cut(rest, "HTTP/1.1 ")
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if keptAlive != tt.keepAlive || keptAlive && !strings.HasPrefix(next, "200 ") {
+				t.Errorf("connection kept alive = %v, want %v", keptAlive, tt.keepAlive)
+			}
+		})
+	}
+}
+
+// headerValue returns the value of the field key in the raw header head.
+func headerValue(head, key string) string {
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		if k, v, ok := strings.Cut(line, ": "); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestResponseFramingHTTP10(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("GET", "/large", func(w ResponseWriter, r *Request) {
+		w.Write([]byte(strings.Repeat("a", 2*bufferLimit)))
+	})
+	s := newTestServer(t)
+	s.handler = router
+
+	// without chunked coding, only closing the connection ends the body
+	raw := exchange(t, s, "GET /large HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /large HTTP/1.0\r\n\r\n")
+	head, body, _ := strings.Cut(raw, "\r\n\r\n")
+	if headerValue(head, "Connection") != "close" || headerValue(head, "Transfer-Encoding") != "" {
+		t.Fatalf("header = %q, want Connection: close without Transfer-Encoding", head)
+	}
+	if body != strings.Repeat("a", 2*bufferLimit) {
+		t.Fatalf("body is %d bytes, want %d", len(body), 2*bufferLimit)
+	}
+}
+
+func TestWriteHeaderDropsInvalidFields(t *testing.T) {
+	h := Header{}
+	h.Set("X-Ok", "fine")
+	h.Add("X-Split", "a\r\nSet-Cookie: injected=1")
+	h.Add("X-Split", "kept")
+	h["Bad Name"] = []string{"v"}
+	h["X-Nul"] = []string{"a\x00b"}
+	var b strings.Builder
+	if err := writeHeader(&b, 200, h, true); err != nil {
+		t.Fatal(err)
+	}
+	resp := parseResponses(t, b.String())[0]
+	if resp.header.Get("X-Ok") != "fine" || strings.Join(resp.header.Values("X-Split"), ",") != "kept" {
+		t.Fatalf("header = %v, want the valid fields kept", resp.header)
+	}
+	for _, key := range []string{"Set-Cookie", "Bad Name", "X-Nul"} {
+		if resp.header.Get(key) != "" {
+			t.Fatalf("header = %v, want no %s", resp.header, key)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServer serves the files under a document root. Requests cannot
+// reach outside of it, neither with ".." nor through symbolic links that
+// point elsewhere. A directory is served by its index.html or, if there is
+// none and listings are enabled, by a listing of its entries.
+//
+// Mounted on a Router under a pattern ending in "{path...}", it serves the
+// file named by that parameter; otherwise it uses the whole request path.
+type FileServer struct {
+	root     *os.Root
+	listings bool
+}
+
+// NewFileServer opens dir as the document root. listings turns on
+// directory listings, in HTML or, for clients that accept it, JSON.
+func NewFileServer(dir string, listings bool) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open document root: %w", err)
+	}
+	return &FileServer{root: root, listings: listings}, nil
+}
+
+func (f *FileServer) ServeHTTP(w ResponseWriter, r *Request) {
+	urlPath, ok := r.params["path"]
+	if !ok {
+		urlPath = r.Path
+	}
+	// cleaning a rooted path drops every ".." that would climb above it
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	file, info, err := f.open(name)
+	if err != nil {
+		f.openError(w, r, name, err)
+		return
+	}
+	defer file.Close()
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.Path, "/") {
+			// relative links in the page only resolve against a trailing slash
+			redirect(w, r, r.Path+"/")
+			return
+		}
+		index, indexInfo, err := f.open(path.Join(name, "index.html"))
+		if err == nil && indexInfo.IsDir() {
+			index.Close()
+			err = os.ErrNotExist
+		}
+		if err != nil {
+			if !f.listings {
+				Error(w, 403, "directory listing is disabled")
+				return
+			}
+			serveListing(w, r, file)
+			return
+		}
+		defer index.Close()
+		file, info, name = index, indexInfo, path.Join(name, "index.html")
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+
+	// a precompressed sibling, such as app.js.gz, is served in place of
+	// the file to clients that accept gzip
+	if gz, gzInfo, err := f.open(name + ".gz"); err == nil {
+		defer gz.Close()
+		if !gzInfo.IsDir() {
+			addVary(w.Header(), "Accept-Encoding")
+			if pickEncoding(r.Header.Values("Accept-Encoding"), "gzip") == "gzip" {
+				w.Header().Set("Content-Encoding", "gzip")
+				file, info = gz, gzInfo
+			}
+		}
+	}
+	serveContent(w, r, file, info.Size(), info.ModTime())
+}
+
+// open opens name within the root. Symbolic links are followed as long as
+// they stay inside it.
+func (f *FileServer) open(name string) (*os.File, os.FileInfo, error) {
+	file, err := f.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+func (f *FileServer) openError(w ResponseWriter, r *Request, name string, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		Error(w, 404, r.Path)
+		return
+	}
+	// most likely a link out of the root, or a file we may not read
+	slog.Warn("refusing to serve file", "path", name, "error", err)
+	Error(w, 403, r.Path)
+}
+
+// redirect sends a client to target, a path on this server. Leading
+// slashes are collapsed, as a Location of "//host/" would take the client
+// to another server.
+func redirect(w ResponseWriter, r *Request, target string) {
+	target = "/" + strings.TrimLeft(target, "/")
+	location := (&url.URL{Path: target, RawQuery: r.RawQuery}).String()
+	w.Header().Set("Location", location)
+	Error(w, 301, location)
+}
+
+// serveContent replies with content of the given size, whose Content-Type
+// is already set. It answers conditional requests with 304 Not Modified
+// and Range requests with 206 Partial Content, sending several ranges as
+// multipart/byteranges.
+func serveContent(w ResponseWriter, r *Request, content io.ReaderAt, size int64, modtime time.Time) {
+	modtime = modtime.UTC().Truncate(time.Second) // the resolution of HTTP dates
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.Unix(), size)
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modtime.Format(TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+
+	if notModified(r, etag, modtime) {
+		// Content-Type and Content-Length stay set, so wrappers such as
+		// Compress see what the 200 would have been; neither goes out
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(304)
+		return
+	}
+
+	var ranges []httpRange
+	if spec := r.Header.Get("Range"); spec != "" && r.Method == "GET" && rangeApplies(r, etag, modtime) {
+		var err error
+		ranges, err = parseRange(spec, size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			Error(w, 416, fmt.Sprintf("no range of %q is within %d bytes", spec, size))
+			return
+		case err != nil:
+			slog.Warn("ignoring malformed Range", "range", spec, "error", err)
+		}
+	}
+
+	var err error
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(200)
+		if r.Method != "HEAD" {
+			_, err = io.Copy(w, io.NewSectionReader(content, 0, size))
+		}
+	case 1:
+		ra := ranges[0]
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(206)
+		_, err = io.Copy(w, io.NewSectionReader(content, ra.start, ra.length))
+	default:
+		err = serveMultipart(w, content, size, ranges)
+	}
+	if err != nil {
+		slog.Error("failed to write response body", "error", err)
+	}
+}
+
+// serveMultipart sends ranges as a multipart/byteranges body (RFC 9110,
+// section 14.6). The body is laid out twice: once to count its length,
+// then for real.
+func serveMultipart(w ResponseWriter, content io.ReaderAt, size int64, ranges []httpRange) error {
+	contentType := w.Header().Get("Content-Type")
+	partHeader := func(ra httpRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {ra.contentRange(size)},
+		}
+	}
+
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	for _, ra := range ranges {
+		mw.CreatePart(partHeader(ra))
+		cw += countingWriter(ra.length)
+	}
+	mw.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(cw), 10))
+	w.WriteHeader(206)
+	body := multipart.NewWriter(w)
+	body.SetBoundary(mw.Boundary())
+	for _, ra := range ranges {
+		part, err := body.CreatePart(partHeader(ra))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, io.NewSectionReader(content, ra.start, ra.length)); err != nil {
+			return err
+		}
+	}
+	return body.Close()
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when that is
+// absent, for GET and HEAD requests (RFC 9110, section 13.2.2).
+func notModified(r *Request, etag string, modtime time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		for _, tag := range splitList(inm) {
+			// If-None-Match uses the weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, ok := parseHTTPDate(r.Header.Get("If-Modified-Since"))
+	return ok && !modtime.After(ims)
+}
+
+// rangeApplies evaluates If-Range: the Range only counts if the client's
+// copy is still current, otherwise it gets the whole content.
+func rangeApplies(r *Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == etag // the strong comparison
+	}
+	t, ok := parseHTTPDate(ir)
+	return ok && t.Equal(modtime)
+}
+
+// parseHTTPDate parses a date in the preferred format or one of the two
+// obsolete ones that recipients must still accept.
+func parseHTTPDate(s string) (time.Time, bool) {
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
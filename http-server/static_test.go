@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeContent(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.Unix(), len(content))
+	lastModified := "Wed, 01 May 2024 12:00:00 GMT"
+
+	tests := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"whole", "GET", nil, 200, content, ""},
+		{"HEAD", "HEAD", nil, 200, "", ""},
+		{"If-None-Match hit", "GET", map[string]string{"If-None-Match": etag}, 304, "", ""},
+		{"If-None-Match weak hit", "GET", map[string]string{"If-None-Match": `"x", W/` + etag}, 304, "", ""},
+		{"If-None-Match any", "HEAD", map[string]string{"If-None-Match": "*"}, 304, "", ""},
+		{"If-None-Match miss", "GET", map[string]string{"If-None-Match": `"other"`}, 200, content, ""},
+		{"If-None-Match wins over If-Modified-Since", "GET", map[string]string{
+			"If-None-Match": `"other"`, "If-Modified-Since": lastModified,
+		}, 200, content, ""},
+		{"If-Modified-Since same", "GET", map[string]string{"If-Modified-Since": lastModified}, 304, "", ""},
+		{"If-Modified-Since later", "GET", map[string]string{"If-Modified-Since": "Thu, 02 May 2024 12:00:00 GMT"}, 304, "", ""},
+		{"If-Modified-Since earlier", "GET", map[string]string{"If-Modified-Since": "Tue, 30 Apr 2024 12:00:00 GMT"}, 200, content, ""},
+		{"If-Modified-Since obsolete format", "GET", map[string]string{"If-Modified-Since": "Wednesday, 01-May-24 12:00:00 GMT"}, 304, "", ""},
+		{"If-Modified-Since garbage", "GET", map[string]string{"If-Modified-Since": "yesterday"}, 200, content, ""},
+		{"range", "GET", map[string]string{"Range": "bytes=2-5"}, 206, "2345", "bytes 2-5/20"},
+		{"suffix range", "GET", map[string]string{"Range": "bytes=-3"}, 206, "hij", "bytes 17-19/20"},
+		{"range on HEAD ignored", "HEAD", map[string]string{"Range": "bytes=2-5"}, 200, "", ""},
+		{"malformed range ignored", "GET", map[string]string{"Range": "bytes=5"}, 200, content, ""},
+		{"unsatisfiable", "GET", map[string]string{"Range": "bytes=50-"}, 416, "", "bytes */20"},
+		{"If-Range etag match", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": etag}, 206, "01", "bytes 0-1/20"},
+		{"If-Range weak etag", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": "W/" + etag}, 200, content, ""},
+		{"If-Range etag mismatch", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`}, 200, content, ""},
+		{"If-Range date match", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": lastModified}, 206, "01", "bytes 0-1/20"},
+		{"If-Range date mismatch", "GET", map[string]string{"Range": "bytes=0-1", "If-Range": "Thu, 02 May 2024 12:00:00 GMT"}, 200, content, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Request{Method: tt.method, Path: "/f.txt", Header: make(Header)}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := newRecorder()
+			w.header.Set("Content-Type", "text/plain")
+			serveContent(w, r, strings.NewReader(content), int64(len(content)), modtime.Add(300*time.Millisecond))
+
+			if w.status != tt.status {
+				t.Fatalf("status = %d, want %d", w.status, tt.status)
+			}
+			if w.status != 416 && w.body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.body.String(), tt.body)
+			}
+			if got := w.header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if got := w.header.Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if got := w.header.Get("Last-Modified"); got != lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, lastModified)
+			}
+			// a 304 describes the full content for wrappers; the response
+			// writer drops these fields on the wire
+			if tt.status == 304 && (w.header.Get("Content-Type") != "text/plain" || w.header.Get("Content-Length") != strconv.Itoa(len(content))) {
+				t.Errorf("304 header = %v, want the 200's Content-Type and Content-Length", w.header)
+			}
+		})
+	}
+}
+
+func TestServeContentMultipart(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	r := &Request{Method: "GET", Path: "/f.txt", Header: make(Header)}
+	r.Header.Set("Range", "bytes=0-1,-2")
+	w := newRecorder()
+	w.header.Set("Content-Type", "text/plain")
+	serveContent(w, r, strings.NewReader(content), int64(len(content)), time.Now())
+
+	if w.status != 206 {
+		t.Fatalf("status = %d, want 206", w.status)
+	}
+	if got, want := w.header.Get("Content-Length"), len(w.body.String()); got != strconv.Itoa(want) {
+		t.Errorf("Content-Length = %s, but the body is %d bytes", got, want)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", w.header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(strings.NewReader(w.body.String()), params["boundary"])
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 18-19/20", "ij"},
+	}
+	for i, part := range want {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(p)
+		if p.Header.Get("Content-Range") != part.contentRange || p.Header.Get("Content-Type") != "text/plain" || string(body) != part.body {
+			t.Errorf("part %d = %v %q, want %s %q", i, p.Header, body, part.contentRange, part.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after the last part: %v, want io.EOF", err)
+	}
+}
+
+// newDocRoot lays out a document root next to a secret it must not serve.
+func newDocRoot(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "www")
+	files := map[string]string{
+		"secret.txt":            "secret",
+		"www/index.html":        "<p>hi</p>",
+		"www/a.txt":             "a",
+		"www/docs/b.txt":        "bb",
+		"www/docs/sub/c.txt":    "c",
+		"www/site/index.html":   "<p>site</p>",
+		"www/odd/index.html/x":  "a directory named index.html",
+		"www/has space/d.txt":   "d",
+		"www/docs/<script>.txt": "e",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"www/inside.txt":  "a.txt",
+		"www/outside.txt": "../secret.txt",
+		"www/up":          "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFileServer(t *testing.T) {
+	root := newDocRoot(t)
+	tests := []struct {
+		name     string
+		listings bool
+		path     string
+		status   int
+		body     string // a substring of the body
+		location string
+	}{
+		{"root index", false, "/", 200, "<p>hi</p>", ""},
+		{"file", false, "/a.txt", 200, "a", ""},
+		{"nested file", false, "/docs/sub/c.txt", 200, "c", ""},
+		{"escaped name", false, "/has space/d.txt", 200, "d", ""},
+		{"missing", false, "/nope.txt", 404, "", ""},
+		{"dot-dot stays inside", false, "/../a.txt", 200, "a", ""},
+		{"dot-dot in the middle", false, "/docs/../a.txt", 200, "a", ""},
+		{"link inside the root", false, "/inside.txt", 200, "a", ""},
+		{"link out of the root", false, "/outside.txt", 403, "", ""},
+		{"link to the parent", false, "/up/secret.txt", 403, "", ""},
+		{"directory index", false, "/site/", 200, "<p>site</p>", ""},
+		{"directory without slash", false, "/site", 301, "", "/site/"},
+		{"redirect keeps escaping", false, "/has space", 301, "", "/has%20space/"},
+		{"no redirect to another host", false, "//site", 301, "", "/site/"},
+		{"no redirect to another host, more slashes", false, "///site", 301, "", "/site/"},
+		{"listing disabled", false, "/docs/", 403, "", ""},
+		{"index.html that is a directory", false, "/odd/", 403, "", ""},
+		{"listing", true, "/docs/", 200, `<a href="sub/">sub/</a>`, ""},
+		{"listing escapes names", true, "/docs/", 200, "&lt;script&gt;.txt", ""},
+		{"listing links to the parent", true, "/docs/", 200, `<a href="../">`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := NewFileServer(root, tt.listings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := serveRequest(files, "GET", tt.path)
+			if w.status != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", w.status, tt.status, w.body.String())
+			}
+			if !strings.Contains(w.body.String(), tt.body) {
+				t.Errorf("body = %q, want it to contain %q", w.body.String(), tt.body)
+			}
+			if got := w.header.Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+			if tt.status == 403 && strings.Contains(w.body.String(), "secret") && !strings.Contains(tt.path, "secret") {
+				t.Errorf("body leaks the secret: %q", w.body.String())
+			}
+		})
+	}
+}
+
+func TestFileServerListingJSON(t *testing.T) {
+	files, err := NewFileServer(newDocRoot(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Request{Method: "GET", Path: "/docs/", Header: make(Header)}
+	r.Header.Set("Accept", "application/json, text/html;q=0.9")
+	w := newRecorder()
+	files.ServeHTTP(w, r)
+
+	if w.status != 200 || w.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("response = %d %q, want 200 JSON", w.status, w.header.Get("Content-Type"))
+	}
+	var entries []dirEntry
+	if err := json.Unmarshal([]byte(w.body.String()), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	// directories first, then by name
+	if want := []string{"sub", "<script>.txt", "b.txt"}; !slices.Equal(names, want) {
+		t.Fatalf("entries = %q, want %q", names, want)
+	}
+	if !entries[0].Dir || entries[2].Size != 2 {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestNewFileServerMissingRoot(t *testing.T) {
+	if _, err := NewFileServer(filepath.Join(t.TempDir(), "nope"), false); err == nil {
+		t.Fatal("NewFileServer succeeded for a missing directory")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake of a new connection.
+const handshakeTimeout = 10 * time.Second
+
+// TLSConfig turns on HTTPS. The certificate for a connection is chosen by
+// the server name the client asks for (SNI), falling back to the first
+// one. Plain HTTP keeps being served on Config.Listen unless RedirectHTTP
+// sends it to HTTPS instead.
+type TLSConfig struct {
+	Listen                string       `json:"listen"`
+	Certificates          []CertConfig `json:"certificates"`
+	RedirectHTTP          bool         `json:"redirectHTTP"`
+	HSTSMaxAge            Duration     `json:"hstsMaxAge"` // no Strict-Transport-Security if zero
+	HSTSIncludeSubdomains bool         `json:"hstsIncludeSubdomains"`
+}
+
+// CertConfig names the PEM files of a certificate chain and its key.
+type CertConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+func (c *TLSConfig) validate() error {
+	if c.Listen == "" {
+		return errors.New("tls listen address is required")
+	}
+	if len(c.Certificates) == 0 {
+		return errors.New("tls needs at least one certificate")
+	}
+	if c.HSTSMaxAge < 0 {
+		return errors.New("hstsMaxAge cannot be negative")
+	}
+	return nil
+}
+
+// serverConfig loads the certificates. Only HTTP/1.1 is offered through
+// ALPN, which tells clients not to try HTTP/2 on this connection.
+func (c *TLSConfig) serverConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+	}
+	for _, cc := range c.Certificates {
+		cert, err := tls.LoadX509KeyPair(cc.Cert, cc.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate %s: %w", cc.Cert, err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	return config, nil
+}
+
+// hsts returns the Strict-Transport-Security value, or "" if disabled.
+func (c *TLSConfig) hsts() string {
+	if c.HSTSMaxAge == 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.FormatInt(int64(time.Duration(c.HSTSMaxAge)/time.Second), 10)
+	if c.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return value
+}
+
+// WithHSTS adds a Strict-Transport-Security header to every response of
+// h, telling browsers to use HTTPS only from now on.
+func WithHSTS(h Handler, value string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RedirectHTTPS sends every request to the same host and target over
+// HTTPS, on the port of tlsAddr.
+func RedirectHTTPS(tlsAddr string) Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Host == "" {
+			Error(w, 400, "Host is required to redirect to HTTPS")
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // an IPv6 literal
+		}
+		if port != "" && port != "443" {
+			host += ":" + port
+		}
+		target := r.Target
+		switch {
+		case r.Path == "*":
+			target = "/"
+		case !strings.HasPrefix(target, "/"):
+			// absolute-form; keep only the path and query
+			target = (&url.URL{Path: r.Path, RawQuery: r.RawQuery}).RequestURI()
+		}
+		location := "https://" + host + target
+		w.Header().Set("Location", location)
+		status := 301
+		if r.Method != "GET" && r.Method != "HEAD" {
+			status = 308 // keeps the method and body
+		}
+		Error(w, status, location)
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for names and its key as PEM
+// files in dir and returns their config.
+func writeCert(t *testing.T, dir string, names ...string) CertConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CertConfig{Cert: filepath.Join(dir, names[0]+".pem"), Key: filepath.Join(dir, names[0]+"-key.pem")}
+	if err := os.WriteFile(cc.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cc.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	tc := &TLSConfig{
+		Listen:       ":0",
+		Certificates: []CertConfig{writeCert(t, dir, "a.test"), writeCert(t, dir, "b.test", "*.b.test")},
+	}
+	config, err := tc.serverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t)
+	handler := WithHSTS(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%s %s", r.TLS.ServerName, r.TLS.NegotiatedProtocol)
+	}), "max-age=60")
+
+	tests := []struct {
+		serverName string
+		nextProtos []string
+		certName   string // the certificate the server presents
+		alpn       string
+	}{
+		{"a.test", []string{"h2", "http/1.1"}, "a.test", "http/1.1"},
+		{"b.test", []string{"http/1.1"}, "b.test", "http/1.1"},
+		{"x.b.test", nil, "b.test", ""},
+		{"unknown.test", nil, "a.test", ""}, // the first certificate is the fallback
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			client, conn := net.Pipe()
+			go s.handleConnection(tls.Server(conn, config), handler)
+
+			tlsClient := tls.Client(client, &tls.Config{
+				ServerName:         tt.serverName,
+				NextProtos:         tt.nextProtos,
+				InsecureSkipVerify: true, // self-signed; the test checks which certificate came back
+			})
+			defer tlsClient.Close()
+			go io.WriteString(tlsClient, "GET / HTTP/1.1\r\nHost: "+tt.serverName+"\r\nConnection: close\r\n\r\n")
+			raw, err := io.ReadAll(tlsClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state := tlsClient.ConnectionState()
+			if got := state.PeerCertificates[0].Subject.CommonName; got != tt.certName {
+				t.Errorf("certificate = %q, want %q", got, tt.certName)
+			}
+			head, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+			if want := tt.serverName + " " + tt.alpn; body != want {
+				t.Errorf("handler saw %q, want %q", body, want)
+			}
+			if got := headerValue(head, "Strict-Transport-Security"); got != "max-age=60" {
+				t.Errorf("Strict-Transport-Security = %q", got)
+			}
+		})
+	}
+}
+
+func TestServeTLSHandshakeFails(t *testing.T) {
+	config, err := (&TLSConfig{Certificates: []CertConfig{writeCert(t, t.TempDir(), "a.test")}}).serverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t)
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConnection(tls.Server(conn, config), s.handler)
+
+	// plain HTTP on the TLS port is not a ClientHello
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	done := make(chan struct{})
+	go func() {
+		io.ReadAll(client)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the connection was not closed after a failed handshake")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	cert := writeCert(t, dir, "a.test")
+	if _, err := (&TLSConfig{Certificates: []CertConfig{{Cert: cert.Cert, Key: filepath.Join(dir, "nope.pem")}}}).serverConfig(); err == nil {
+		t.Error("serverConfig succeeded with a missing key")
+	}
+	if _, err := (&TLSConfig{Certificates: []CertConfig{{Cert: cert.Key, Key: cert.Cert}}}).serverConfig(); err == nil {
+		t.Error("serverConfig succeeded with the files swapped")
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		config TLSConfig
+		want   string
+	}{
+		{TLSConfig{}, ""},
+		{TLSConfig{HSTSMaxAge: Duration(8760 * time.Hour)}, "max-age=31536000"},
+		{TLSConfig{HSTSMaxAge: Duration(90 * time.Second), HSTSIncludeSubdomains: true}, "max-age=90; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		if got := tt.config.hsts(); got != tt.want {
+			t.Errorf("hsts(%+v) = %q, want %q", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		tlsAddr  string
+		method   string
+		host     string
+		target   string
+		status   int
+		location string
+	}{
+		{"default port", ":443", "GET", "example.com", "/a?b=c", 301, "https://example.com/a?b=c"},
+		{"other port", ":8443", "GET", "example.com:8080", "/a", 301, "https://example.com:8443/a"},
+		{"IPv6", ":8443", "GET", "[::1]:8080", "/", 301, "https://[::1]:8443/"},
+		{"HEAD", ":443", "HEAD", "example.com", "/", 301, "https://example.com/"},
+		{"POST keeps its method", ":443", "POST", "example.com", "/form", 308, "https://example.com/form"},
+		{"absolute form", ":443", "GET", "example.com", "http://example.com/a%20b?x=1", 301, "https://example.com/a%20b?x=1"},
+		{"asterisk", ":443", "OPTIONS", "example.com", "*", 308, "https://example.com/"},
+		{"no Host", ":443", "GET", "", "/", 400, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tt.method + " " + tt.target + " HTTP/1.1\r\nHost: " + tt.host + "\r\n\r\n"
+			if tt.host == "" {
+				raw = tt.method + " " + tt.target + " HTTP/1.0\r\n\r\n"
+			}
+			r, err := readRequest(bufio.NewReader(strings.NewReader(raw)), 1024, 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newRecorder()
+			RedirectHTTPS(tt.tlsAddr).ServeHTTP(w, r)
+			if w.status != tt.status || w.header.Get("Location") != tt.location {
+				t.Fatalf("response = %d %q, want %d %q", w.status, w.header.Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// VirtualHosts dispatches requests to a handler by the host they are
+// addressed to, falling back to the default handler for unknown hosts.
+type VirtualHosts struct {
+	hosts     map[string]Handler // exact names
+	wildcards map[string]Handler // "*.example.com" stored as ".example.com"
+	fallback  Handler
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{hosts: make(map[string]Handler), wildcards: make(map[string]Handler)}
+}
+
+// Handle serves the requests for name with h. A name starting with "*."
+// matches any subdomain that has no handler of its own.
+func (v *VirtualHosts) Handle(name string, h Handler) {
+	name = normalizeHost(name)
+	if suffix, ok := strings.CutPrefix(name, "*"); ok && strings.HasPrefix(suffix, ".") {
+		v.wildcards[suffix] = h
+		return
+	}
+	v.hosts[name] = h
+}
+
+// HandleDefault serves the requests for every host without a handler of
+// its own with h.
+func (v *VirtualHosts) HandleDefault(h Handler) {
+	v.fallback = h
+}
+
+func (v *VirtualHosts) ServeHTTP(w ResponseWriter, r *Request) {
+	if h := v.lookup(r.Host); h != nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	Error(w, 404, "unknown host "+r.Host)
+}
+
+func (v *VirtualHosts) lookup(host string) Handler {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = normalizeHost(strings.Trim(host, "[]"))
+	if h, ok := v.hosts[host]; ok {
+		return h
+	}
+	// trying the longest suffix first lets *.a.example.com beat *.example.com
+	for i := strings.IndexByte(host, '.'); i >= 0; {
+		if h, ok := v.wildcards[host[i:]]; ok {
+			return h
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return v.fallback
+}
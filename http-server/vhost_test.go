@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	v := NewVirtualHosts()
+	named := func(name string) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Write([]byte(name))
+		})
+	}
+	v.Handle("example.com", named("example"))
+	v.Handle("WWW.Example.com", named("www"))
+	v.Handle("*.example.com", named("wildcard"))
+	v.Handle("*.api.example.com", named("api wildcard"))
+	v.Handle("::1", named("ipv6"))
+
+	tests := []struct {
+		host string
+		want string // "" for a 404
+	}{
+		{"example.com", "example"},
+		{"example.com:8080", "example"},
+		{"EXAMPLE.com.", "example"},
+		{"www.example.com", "www"},
+		{"blog.example.com", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"v1.api.example.com", "api wildcard"},
+		{"api.example.com", "wildcard"},
+		{"[::1]:8080", "ipv6"},
+		{"other.com", ""},
+		{"badexample.com", ""},
+		{"", ""},
+	}
+	check := func(t *testing.T, v *VirtualHosts, host, want string, fallback int) {
+		t.Helper()
+		w := newRecorder()
+		v.ServeHTTP(w, &Request{Method: "GET", Path: "/", Host: host, Header: make(Header)})
+		if want == "" {
+			if w.status != fallback {
+				t.Errorf("host %q: status %d, want %d", host, w.status, fallback)
+			}
+			return
+		}
+		if w.body.String() != want {
+			t.Errorf("host %q: served by %q, want %q", host, w.body.String(), want)
+		}
+	}
+	for _, tt := range tests {
+		check(t, v, tt.host, tt.want, 404)
+	}
+
+	v.HandleDefault(HandlerFunc(func(w ResponseWriter, r *Request) { w.WriteHeader(204) }))
+	check(t, v, "other.com", "", 204)
+	check(t, v, "blog.example.com", "wildcard", 204)
+}
+
+func TestRoutesFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/index.html": "docs",
+		"www/index.html":  "www",
+		"docs/sub/x.txt":  "x",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler, err := routes(&Config{Hosts: []HostConfig{
+		{Names: []string{"docs.test", "*.docs.test"}, Root: filepath.Join(dir, "docs"), Listings: true},
+		{Default: true, Root: filepath.Join(dir, "www"), Health: "/healthz"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, path string
+		status     int
+		body       string
+	}{
+		{"docs.test", "/", 200, "docs"},
+		{"v2.docs.test:8080", "/", 200, "docs"},
+		{"other.test", "/", 200, "www"},
+		{"docs.test", "/sub/", 200, "x.txt"}, // listings are on for docs only
+		{"other.test", "/sub/", 404, ""},
+		{"other.test", "/healthz", 200, `"status":"ok"`},
+		{"docs.test", "/healthz", 404, ""}, // the health check is per host
+		{"other.test", "/api/health", 404, ""},
+	}
+	for _, tt := range tests {
+		w := newRecorder()
+		handler.ServeHTTP(w, &Request{Method: "GET", Path: tt.path, Host: tt.host, Header: make(Header)})
+		if w.status != tt.status || tt.body != "" && !strings.Contains(w.body.String(), tt.body) {
+			t.Errorf("%s%s = %d %.40q, want %d with %q", tt.host, tt.path, w.status, w.body.String(), tt.status, tt.body)
+		}
+	}
+
+	if _, err := routes(&Config{Hosts: []HostConfig{{Default: true, Root: filepath.Join(dir, "nope")}}}); err == nil {
+		t.Fatal("routes succeeded with a missing root")
+	}
+}
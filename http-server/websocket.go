@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to the client's key to compute the accept key
+// (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultMaxMessageSize bounds a message, fragments included, when the
+// WebSocketHandler does not set a limit.
+const defaultMaxMessageSize = 1 << 20
+
+// closeTimeout is how long Close waits for the peer to answer the close
+// handshake.
+const closeTimeout = 5 * time.Second
+
+// Message types, which are the opcodes of their frames.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Close status codes (RFC 6455, section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// CloseError is returned by ReadMessage once the connection is closing,
+// with the status the closing side gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// WebSocketHandler upgrades requests to WebSocket connections (RFC 6455)
+// and hands them to Serve. Bind it to a GET route:
+//
+//	router.Handle("GET", "/live", &WebSocketHandler{Serve: dashboard})
+//
+// The connection is closed when Serve returns. The server's timeouts no
+// longer apply once the connection is upgraded, so Serve should bound its
+// reads with SetReadDeadline.
+type WebSocketHandler struct {
+	Serve func(ws *WebSocket, r *Request)
+
+	// Subprotocols the server speaks, in order of preference.
+	Subprotocols []string
+
+	// CheckOrigin decides whether a browser page from another origin may
+	// connect. If nil, only pages served from the requested host may.
+	CheckOrigin func(r *Request) bool
+
+	// MaxMessageSize bounds incoming messages; 0 means 1 MiB.
+	MaxMessageSize int64
+}
+
+func (h *WebSocketHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "GET" || r.ProtoMinor < 1 {
+		Error(w, 400, "websocket handshake needs an HTTP/1.1 GET request")
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		Error(w, 426, "this resource is only available over websocket")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		Error(w, 426, "unsupported websocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		Error(w, 400, "invalid Sec-WebSocket-Key")
+		return
+	}
+	checkOrigin := h.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		Error(w, 403, "origin not allowed")
+		return
+	}
+	subprotocol := h.selectSubprotocol(r)
+
+	hj, ok := w.(Hijacker)
+	if !ok {
+		Error(w, 500, "connection cannot be upgraded")
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		slog.Error("failed to hijack connection", "error", err)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(&b, "Sec-WebSocket-Accept: %s\r\n", acceptKey(key))
+	if subprotocol != "" {
+		fmt.Fprintf(&b, "Sec-WebSocket-Protocol: %s\r\n", subprotocol)
+	}
+	b.WriteString("\r\n")
+	if _, err := rw.WriteString(b.String()); err != nil {
+		slog.Error("failed to write websocket handshake", "error", err)
+		return
+	}
+	if err := rw.Flush(); err != nil {
+		slog.Error("failed to write websocket handshake", "error", err)
+		return
+	}
+
+	maxSize := h.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageSize
+	}
+	ws := &WebSocket{
+		Subprotocol:    subprotocol,
+		conn:           conn,
+		br:             rw.Reader,
+		bw:             rw.Writer,
+		maxMessageSize: maxSize,
+	}
+	slog.Info("websocket connected", "remote address", r.RemoteAddr, "path", r.Path, "subprotocol", subprotocol)
+	h.Serve(ws, r)
+	ws.Close(CloseNormal, "")
+}
+
+func (h *WebSocketHandler) selectSubprotocol(r *Request) string {
+	offered := splitList(r.Header.Values("Sec-WebSocket-Protocol"))
+	for _, p := range h.Subprotocols {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// sameOrigin allows requests whose Origin names the requested host.
+// Browsers always send Origin, so a request without one does not come
+// from a web page and is allowed too.
+func sameOrigin(r *Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether the comma-separated field key lists
+// token, ignoring case.
+func headerHasToken(h Header, key, token string) bool {
+	for _, v := range splitList(h.Values(key)) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocket is a server-side WebSocket connection. One goroutine may read
+// messages while others write; pings are answered while reading.
+type WebSocket struct {
+	Subprotocol string // negotiated with the client; "" if none
+
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int64
+
+	readErr error // sticky once the connection has failed or is closing
+
+	writeMu   sync.Mutex // guards bw and closeSent
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+// frame is one parsed frame header.
+type frame struct {
+	fin    bool
+	opcode int
+	length int64
+	mask   [4]byte
+}
+
+// ReadMessage returns the next data message, reassembled from its
+// fragments. Control frames in between are handled: pings are answered
+// and a close frame is echoed, after which ReadMessage returns a
+// *CloseError.
+func (ws *WebSocket) ReadMessage() (messageType int, data []byte, err error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+	messageType, data, err = ws.readMessage()
+	if err != nil {
+		ws.readErr = err
+	}
+	return messageType, data, err
+}
+
+func (ws *WebSocket) readMessage() (int, []byte, error) {
+	var messageType int
+	var data []byte
+	for {
+		f, err := ws.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if f.opcode >= opClose {
+			payload, err := ws.readPayload(f)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := ws.handleControl(f.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		switch {
+		case f.opcode == opContinuation && messageType == 0:
+			return 0, nil, ws.fail(CloseProtocolError, "continuation frame without a message")
+		case f.opcode != opContinuation && messageType != 0:
+			return 0, nil, ws.fail(CloseProtocolError, "new message before the last one ended")
+		case f.opcode != opContinuation:
+			messageType = f.opcode
+		}
+		if int64(len(data))+f.length > ws.maxMessageSize {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		payload, err := ws.readPayload(f)
+		if err != nil {
+			return 0, nil, err
+		}
+		data = append(data, payload...)
+
+		if f.fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return 0, nil, ws.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// readFrameHeader reads and checks a frame header (RFC 6455, section 5.2).
+func (ws *WebSocket) readFrameHeader() (frame, error) {
+	var f frame
+	var head [2]byte
+	if _, err := io.ReadFull(ws.br, head[:]); err != nil {
+		return f, err
+	}
+	f.fin = head[0]&0x80 != 0
+	f.opcode = int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	f.length = int64(head[1] & 0x7f)
+
+	if head[0]&0x70 != 0 {
+		return f, ws.fail(CloseProtocolError, "reserved bits set without an extension")
+	}
+	switch f.opcode {
+	case opContinuation, TextMessage, BinaryMessage:
+	case opClose, opPing, opPong:
+		if !f.fin || f.length > 125 {
+			return f, ws.fail(CloseProtocolError, "control frames must be short and unfragmented")
+		}
+	default:
+		return f, ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+	}
+	if !masked {
+		return f, ws.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	switch f.length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return f, err
+		}
+		f.length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return f, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return f, ws.fail(CloseProtocolError, "invalid frame length")
+		}
+		f.length = int64(n)
+	}
+	if f.length > ws.maxMessageSize {
+		return f, ws.fail(CloseMessageTooBig, "frame too big")
+	}
+	if _, err := io.ReadFull(ws.br, f.mask[:]); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func (ws *WebSocket) readPayload(f frame) ([]byte, error) {
+	payload := make([]byte, f.length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= f.mask[i%4]
+	}
+	return payload, nil
+}
+
+// handleControl answers a ping or close frame. For a close frame it
+// returns the *CloseError that ends reading.
+func (ws *WebSocket) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case opPing:
+		if err := ws.writeFrame(opPong, payload); !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil // our close frame is out, and it answers the ping too
+	case opPong:
+		return nil // a reply to our ping; nothing to do
+	}
+
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return ws.fail(CloseProtocolError, "malformed close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return ws.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return ws.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+	// echo the status back, which completes the close handshake
+	echo := closeErr.Code
+	if echo == CloseNoStatus {
+		echo = CloseNormal
+	}
+	ws.sendClose(echo, "")
+	return closeErr
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true // registered or private use
+	}
+	return false
+}
+
+// fail starts closing the connection with code after a protocol
+// violation by the peer and returns the error that ends reading.
+func (ws *WebSocket) fail(code int, reason string) error {
+	ws.sendClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage sends data as a single frame of messageType.
+func (ws *WebSocket) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("invalid message type %d", messageType)
+	}
+	return ws.writeFrame(messageType, data)
+}
+
+// WriteText sends s as a text message.
+func (ws *WebSocket) WriteText(s string) error {
+	return ws.WriteMessage(TextMessage, []byte(s))
+}
+
+// Ping sends a ping, which the client answers with a pong carrying data.
+func (ws *WebSocket) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("ping payload longer than 125 bytes")
+	}
+	return ws.writeFrame(opPing, data)
+}
+
+// writeFrame sends an unmasked frame, as servers must.
+func (ws *WebSocket) writeFrame(opcode int, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		ws.closeSent = true
+	}
+
+	head := []byte{0x80 | byte(opcode), 0}
+	switch n := len(payload); {
+	case n <= 125:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	if _, err := ws.bw.Write(head); err != nil {
+		return err
+	}
+	if _, err := ws.bw.Write(payload); err != nil {
+		return err
+	}
+	return ws.bw.Flush()
+}
+
+func (ws *WebSocket) sendClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123] // control frames carry at most 125 bytes
+	}
+	return ws.writeFrame(opClose, append(payload, reason...))
+}
+
+// Close starts the close handshake with code and reason and waits a while
+// for the client to answer it. It must not be called while another
+// goroutine is in ReadMessage; that goroutine sees the client's answer
+// as a *CloseError instead.
+func (ws *WebSocket) Close(code int, reason string) error {
+	if err := ws.sendClose(code, reason); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	if ws.readErr != nil {
+		return nil // the client's close frame is in, or nobody is left to send it
+	}
+
+	// drain messages until the client's close frame arrives
+	ws.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	defer ws.conn.SetReadDeadline(time.Time{})
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			var ce *CloseError
+			if errors.As(err, &ce) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// SetReadDeadline bounds how long ReadMessage waits; a WebSocket is not
+// subject to the server's idle timeout.
+func (ws *WebSocket) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the client's network address.
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
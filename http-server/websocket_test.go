@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientFrame encodes a frame as a client sends it: masked, with the
+// given first byte (FIN, RSV bits and opcode).
+func clientFrame(b0 byte, payload []byte) []byte {
+	return frameWithMask(b0, payload, true)
+}
+
+func frameWithMask(b0 byte, payload []byte, masked bool) []byte {
+	b := []byte{b0, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+	b[1] |= 0x80
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// serverFrame is a frame the server sent, which it must not mask.
+type serverFrame struct {
+	opcode  int
+	payload []byte
+}
+
+func readServerFrames(t *testing.T, b []byte) []serverFrame {
+	t.Helper()
+	var frames []serverFrame
+	for len(b) > 0 {
+		if len(b) < 2 || b[0]&0x80 == 0 || b[1]&0x80 != 0 {
+			t.Fatalf("malformed server frame % x", b)
+		}
+		n, b2 := int(b[1]&0x7f), b[2:]
+		switch n {
+		case 126:
+			n, b2 = int(binary.BigEndian.Uint16(b2)), b2[2:]
+		case 127:
+			n, b2 = int(binary.BigEndian.Uint64(b2)), b2[8:]
+		}
+		frames = append(frames, serverFrame{opcode: int(b[0] & 0x0f), payload: b2[:n]})
+		b = b2[n:]
+	}
+	return frames
+}
+
+func newTestWebSocket(in []byte, out *bytes.Buffer) *WebSocket {
+	return &WebSocket{
+		br:             bufio.NewReader(bytes.NewReader(in)),
+		bw:             bufio.NewWriter(out),
+		maxMessageSize: 64,
+	}
+}
+
+func TestWebSocketReadMessage(t *testing.T) {
+	const fin = 0x80
+	tests := []struct {
+		name     string
+		in       [][]byte
+		wantType int
+		want     string
+		close    int    // the close code ReadMessage fails with; 0 if a message is read
+		sent     []byte // the server's answer, if any: a pong payload or a close code
+	}{
+		{name: "text", in: [][]byte{clientFrame(fin|TextMessage, []byte("hello"))}, wantType: TextMessage, want: "hello"},
+		{name: "binary", in: [][]byte{clientFrame(fin|BinaryMessage, []byte{0, 0xff})}, wantType: BinaryMessage, want: "\x00\xff"},
+		{name: "empty", in: [][]byte{clientFrame(fin|TextMessage, nil)}, wantType: TextMessage, want: ""},
+		{name: "at size limit", in: [][]byte{clientFrame(fin|BinaryMessage, bytes.Repeat([]byte{'a'}, 64))}, wantType: BinaryMessage, want: strings.Repeat("a", 64)},
+		{
+			name: "fragmented",
+			in: [][]byte{
+				clientFrame(TextMessage, []byte("hel")),
+				clientFrame(opContinuation, []byte("l")),
+				clientFrame(fin|opContinuation, []byte("o")),
+			},
+			wantType: TextMessage,
+			want:     "hello",
+		},
+		{
+			name: "ping between fragments",
+			in: [][]byte{
+				clientFrame(TextMessage, []byte("hel")),
+				clientFrame(fin|opPing, []byte("p")),
+				clientFrame(fin|opContinuation, []byte("lo")),
+			},
+			wantType: TextMessage,
+			want:     "hello",
+			sent:     []byte("p"),
+		},
+		{
+			name:     "pong ignored",
+			in:       [][]byte{clientFrame(fin|opPong, []byte("x")), clientFrame(fin|TextMessage, []byte("a"))},
+			wantType: TextMessage,
+			want:     "a",
+		},
+		{
+			name:     "UTF-8 split across fragments",
+			in:       [][]byte{clientFrame(TextMessage, []byte("\xc3")), clientFrame(fin|opContinuation, []byte("\xa9"))},
+			wantType: TextMessage,
+			want:     "é",
+		},
+
+		{name: "close", in: [][]byte{clientFrame(fin|opClose, closePayload(CloseGoingAway, "bye"))}, close: CloseGoingAway, sent: closePayload(CloseGoingAway, "")},
+		{name: "close without status", in: [][]byte{clientFrame(fin|opClose, nil)}, close: CloseNoStatus, sent: closePayload(CloseNormal, "")},
+		{name: "close with private code", in: [][]byte{clientFrame(fin|opClose, closePayload(4000, ""))}, close: 4000, sent: closePayload(4000, "")},
+		{name: "one-byte close", in: [][]byte{clientFrame(fin|opClose, []byte{3})}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "reserved close code", in: [][]byte{clientFrame(fin|opClose, closePayload(1005, ""))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "unassigned close code", in: [][]byte{clientFrame(fin|opClose, closePayload(999, ""))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "invalid close reason", in: [][]byte{clientFrame(fin|opClose, closePayload(CloseNormal, "\xff"))}, close: CloseInvalidPayload, sent: closePayload(CloseInvalidPayload, "")},
+
+		{name: "unmasked", in: [][]byte{frameWithMask(fin|TextMessage, []byte("a"), false)}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "RSV1", in: [][]byte{clientFrame(fin|0x40|TextMessage, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "RSV3", in: [][]byte{clientFrame(fin|0x10|TextMessage, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "unknown data opcode", in: [][]byte{clientFrame(fin|3, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "unknown control opcode", in: [][]byte{clientFrame(fin|0xb, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "fragmented ping", in: [][]byte{clientFrame(opPing, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "fragmented close", in: [][]byte{clientFrame(opClose, nil)}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "long ping", in: [][]byte{clientFrame(fin|opPing, bytes.Repeat([]byte{'a'}, 126))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{name: "continuation first", in: [][]byte{clientFrame(fin|opContinuation, []byte("a"))}, close: CloseProtocolError, sent: closePayload(CloseProtocolError, "")},
+		{
+			name:  "new message mid-message",
+			in:    [][]byte{clientFrame(TextMessage, []byte("a")), clientFrame(fin|TextMessage, []byte("b"))},
+			close: CloseProtocolError,
+			sent:  closePayload(CloseProtocolError, ""),
+		},
+		{name: "invalid UTF-8", in: [][]byte{clientFrame(fin|TextMessage, []byte("\xff"))}, close: CloseInvalidPayload, sent: closePayload(CloseInvalidPayload, "")},
+		{name: "binary need not be UTF-8", in: [][]byte{clientFrame(fin|BinaryMessage, []byte("\xff"))}, wantType: BinaryMessage, want: "\xff"},
+
+		{name: "frame too big", in: [][]byte{clientFrame(fin|BinaryMessage, bytes.Repeat([]byte{'a'}, 65))}, close: CloseMessageTooBig, sent: closePayload(CloseMessageTooBig, "")},
+		{
+			name:  "fragments too big",
+			in:    [][]byte{clientFrame(BinaryMessage, bytes.Repeat([]byte{'a'}, 40)), clientFrame(fin|opContinuation, bytes.Repeat([]byte{'a'}, 40))},
+			close: CloseMessageTooBig,
+			sent:  closePayload(CloseMessageTooBig, ""),
+		},
+		{
+			// a 64-bit length with the top bit set is not allowed
+			name:  "negative 64-bit length",
+			in:    [][]byte{{fin | BinaryMessage, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 1}},
+			close: CloseProtocolError,
+			sent:  closePayload(CloseProtocolError, ""),
+		},
+		{
+			// refused from the header alone, before any payload is buffered
+			name:  "huge 64-bit length",
+			in:    [][]byte{{fin | BinaryMessage, 0x80 | 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+			close: CloseMessageTooBig,
+			sent:  closePayload(CloseMessageTooBig, ""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			ws := newTestWebSocket(bytes.Join(tt.in, nil), &out)
+			messageType, data, err := ws.ReadMessage()
+
+			if tt.close != 0 {
+				var ce *CloseError
+				if !errors.As(err, &ce) || ce.Code != tt.close {
+					t.Fatalf("ReadMessage error = %v, want close code %d", err, tt.close)
+				}
+				if _, _, again := ws.ReadMessage(); again != err {
+					t.Errorf("second ReadMessage error = %v, want the first one again", again)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("ReadMessage: %v", err)
+				}
+				if messageType != tt.wantType || string(data) != tt.want {
+					t.Fatalf("ReadMessage = %d %q, want %d %q", messageType, data, tt.wantType, tt.want)
+				}
+			}
+
+			frames := readServerFrames(t, out.Bytes())
+			if tt.sent == nil {
+				if len(frames) != 0 {
+					t.Fatalf("server sent %v, want nothing", frames)
+				}
+				return
+			}
+			if len(frames) != 1 {
+				t.Fatalf("server sent %v, want one frame", frames)
+			}
+			got := frames[0].payload
+			if tt.close != 0 {
+				// compare the status code only; the reason is free text
+				if frames[0].opcode != opClose || len(got) < 2 || !bytes.Equal(got[:2], tt.sent) {
+					t.Fatalf("server sent %d % x, want close % x", frames[0].opcode, got, tt.sent)
+				}
+				return
+			}
+			if frames[0].opcode != opPong || !bytes.Equal(got, tt.sent) {
+				t.Fatalf("server sent %d %q, want pong %q", frames[0].opcode, got, tt.sent)
+			}
+		})
+	}
+}
+
+func TestWebSocketReadTruncated(t *testing.T) {
+	full := clientFrame(0x80|BinaryMessage, bytes.Repeat([]byte{'a'}, 20))
+	for n := range len(full) {
+		var out bytes.Buffer
+		_, _, err := newTestWebSocket(full[:n], &out).ReadMessage()
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("frame cut to %d bytes: err = %v, want EOF", n, err)
+		}
+	}
+}
+
+func TestWebSocketWriteFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		head []byte
+	}{
+		{"short", 5, []byte{0x82, 5}},
+		{"125", 125, []byte{0x82, 125}},
+		{"126", 126, []byte{0x82, 126, 0, 126}},
+		{"65535", 65535, []byte{0x82, 126, 0xff, 0xff}},
+		{"65536", 65536, []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			ws := newTestWebSocket(nil, &out)
+			if err := ws.WriteMessage(BinaryMessage, make([]byte, tt.n)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(out.Bytes(), tt.head) || out.Len() != len(tt.head)+tt.n {
+				t.Fatalf("frame starts % x and is %d bytes, want % x and %d", out.Bytes()[:len(tt.head)], out.Len(), tt.head, len(tt.head)+tt.n)
+			}
+		})
+	}
+}
+
+func TestWebSocketWriteAfterClose(t *testing.T) {
+	var out bytes.Buffer
+	ws := newTestWebSocket(nil, &out)
+	if err := ws.sendClose(CloseNormal, strings.Repeat("r", 200)); err != nil {
+		t.Fatal(err)
+	}
+	frames := readServerFrames(t, out.Bytes())
+	if len(frames) != 1 || len(frames[0].payload) != 125 {
+		t.Fatalf("close frame = %v, want a payload truncated to 125 bytes", frames)
+	}
+	if err := ws.WriteText("late"); err == nil {
+		t.Fatal("WriteText after the close frame succeeded")
+	}
+	if err := ws.WriteMessage(opPing, nil); err == nil {
+		t.Fatal("WriteMessage accepted a control opcode")
+	}
+	if err := ws.Ping(make([]byte, 126)); err == nil {
+		t.Fatal("Ping accepted a payload over 125 bytes")
+	}
+}
+
+func TestWebSocketCloseDrains(t *testing.T) {
+	client, conn := net.Pipe()
+	defer client.Close()
+	ws := &WebSocket{conn: conn, br: bufio.NewReader(conn), bw: bufio.NewWriter(conn), maxMessageSize: 64}
+
+	received := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(client)
+		received <- b
+	}()
+	// what the client had in flight when the server's close frame came
+	go func() {
+		client.Write(clientFrame(0x80|opPing, []byte("p")))
+		client.Write(clientFrame(0x80|TextMessage, []byte("late")))
+		client.Write(clientFrame(0x80|opClose, closePayload(CloseNormal, "")))
+	}()
+
+	if err := ws.Close(CloseGoingAway, "bye"); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	conn.Close()
+	frames := readServerFrames(t, <-received)
+	if len(frames) != 1 || frames[0].opcode != opClose || !bytes.Equal(frames[0].payload, closePayload(CloseGoingAway, "bye")) {
+		t.Fatalf("server sent %v, want only its close frame", frames)
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	valid := "GET /ws HTTP/1.1\r\nHost: example.com\r\n" +
+		"Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+	}{
+		{"POST", strings.Replace(valid, "GET", "POST", 1), 400},
+		{"HTTP/1.0", strings.Replace(valid, "HTTP/1.1", "HTTP/1.0", 1), 400},
+		{"no Upgrade", strings.Replace(valid, "Upgrade: websocket\r\n", "", 1), 426},
+		{"no Connection upgrade", strings.Replace(valid, "keep-alive, Upgrade", "keep-alive", 1), 426},
+		{"old version", strings.Replace(valid, "Version: 13", "Version: 8", 1), 426},
+		{"no key", strings.Replace(valid, "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n", "", 1), 400},
+		{"short key", strings.Replace(valid, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1), 400},
+		{"key not base64", strings.Replace(valid, "dGhlIHNhbXBsZSBub25jZQ==", "not base64!!", 1), 400},
+		{"cross origin", valid + "Origin: https://evil.example\r\n", 403},
+		// the handshake checks pass, but recorder cannot be hijacked
+		{"same origin", valid + "Origin: https://Example.com\r\n", 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readRequest(bufio.NewReader(strings.NewReader(tt.raw+"\r\n")), 4096, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newRecorder()
+			h := &WebSocketHandler{Serve: func(*WebSocket, *Request) { t.Error("Serve called") }}
+			h.ServeHTTP(w, req)
+			if w.status != tt.status {
+				t.Fatalf("status = %d (%s), want %d", w.status, strings.TrimSpace(w.body.String()), tt.status)
+			}
+		})
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455, section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("acceptKey = %q", got)
+	}
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/ws", &WebSocketHandler{
+		Subprotocols: []string{"chat", "json"},
+		Serve: func(ws *WebSocket, r *Request) {
+			for {
+				messageType, data, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := ws.WriteMessage(messageType, append([]byte(ws.Subprotocol+": "), data...)); err != nil {
+					return
+				}
+			}
+		},
+	})
+	s := newTestServer(t)
+	s.handler = Compress(router) // upgrades work through the middleware
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConnection(conn, s.handler)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	go io.WriteString(client, "GET /ws HTTP/1.1\r\nHost: example.com\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nAccept-Encoding: gzip\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Protocol: json, chat\r\n\r\n")
+	br := bufio.NewReader(client)
+	var head []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		head = append(head, strings.TrimSpace(line))
+	}
+	want := []string{
+		"HTTP/1.1 101 Switching Protocols",
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		"Sec-WebSocket-Protocol: chat", // the server's preference wins
+	}
+	if !slices.Equal(head, want) {
+		t.Fatalf("handshake response = %q, want %q", head, want)
+	}
+
+	// frames from the client go out as it writes them; net.Pipe blocks
+	// each write until the server has read it
+	go func() {
+		client.Write(clientFrame(0x80|TextMessage, []byte("hi")))
+		client.Write(clientFrame(0x80|opClose, closePayload(CloseNormal, "")))
+	}()
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := readServerFrames(t, rest)
+	if len(frames) != 2 || frames[0].opcode != TextMessage || string(frames[0].payload) != "chat: hi" ||
+		frames[1].opcode != opClose || !bytes.Equal(frames[1].payload[:2], closePayload(CloseNormal, "")) {
+		t.Fatalf("server sent %q, want the echo and then a normal close", frames)
+	}
+}